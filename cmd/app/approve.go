@@ -16,9 +16,13 @@ type mergeRequestApproverService struct {
 	client MergeRequestApprover
 }
 
+func (a mergeRequestApproverService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPost: SuccessResponse{}}
+}
+
 /* approveHandler approves a merge request. */
 func (a mergeRequestApproverService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	_, res, err := a.client.ApproveMergeRequest(a.projectInfo.ProjectId, a.projectInfo.MergeId, nil, nil)
+	_, res, err := a.client.ApproveMergeRequest(a.projectId(r), a.mergeId(r), nil, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not approve merge request", http.StatusInternalServerError)
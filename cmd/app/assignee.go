@@ -22,6 +22,10 @@ type assigneesService struct {
 	client MergeRequestUpdater
 }
 
+func (a assigneesService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPut: AssigneeUpdateResponse{}}
+}
+
 /* assigneesHandler adds or removes assignees from a merge request. */
 func (a assigneesService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
@@ -32,9 +36,9 @@ func (a assigneesService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mr, res, err := a.client.UpdateMergeRequest(a.projectInfo.ProjectId, a.projectInfo.MergeId, &gitlab.UpdateMergeRequestOptions{
+	mr, res, err := a.client.UpdateMergeRequest(a.projectId(r), a.mergeId(r), &gitlab.UpdateMergeRequestOptions{
 		AssigneeIDs: &assigneeUpdateRequest.Ids,
-	})
+	}, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not modify merge request assignees", http.StatusInternalServerError)
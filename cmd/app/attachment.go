@@ -57,6 +57,10 @@ type attachmentService struct {
 	client     FileUploader
 }
 
+func (a attachmentService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPost: AttachmentResponse{}}
+}
+
 /* attachmentHandler uploads an attachment (file, image, etc) to Gitlab and returns metadata about the upload. */
 func (a attachmentService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	payload := r.Context().Value(payload("payload")).(*AttachmentRequest)
@@ -67,7 +71,7 @@ func (a attachmentService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	projectFile, res, err := a.client.UploadProjectMarkdown(a.projectInfo.ProjectId, file, payload.FileName)
+	projectFile, res, err := a.client.UploadProjectMarkdown(a.projectId(r), file, payload.FileName, gitlab.WithContext(r.Context()))
 	if err != nil {
 		handleError(w, err, fmt.Sprintf("Could not upload %s to Gitlab", payload.FileName), http.StatusInternalServerError)
 		return
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+const maxConcurrentBatchRequests = 8
+
+/* Marks the sub-requests of a batch, so that a batch can never be sent from inside another one */
+const batchKey = contextKey("batch")
+
+type BatchSubRequest struct {
+	Method     string          `json:"method" validate:"required"`
+	Path       string          `json:"path" validate:"required,startswith=/"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	Sequential bool            `json:"sequential"`
+}
+
+type BatchRequest struct {
+	Requests []BatchSubRequest `json:"requests" validate:"required,min=1,dive"`
+}
+
+type BatchResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+type BatchResponse struct {
+	SuccessResponse
+	Results []BatchResult `json:"results"`
+}
+
+type batchService struct {
+	handler http.Handler
+}
+
+func (a batchService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPost: BatchResponse{}}
+}
+
+/*
+batchHandler runs several API calls in one round trip. Every sub-request goes through the full router,
+so that authentication, method checks and payload validation apply exactly as they would over HTTP.
+Sub-requests run concurrently, except that one marked "sequential" waits for every earlier sub-request
+to finish first. A failing sub-request is reported in its result and never stops the others.
+*/
+func (a batchService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Value(batchKey) != nil {
+		handleError(w, InvalidRequestError{"batch requests cannot be nested"}, "Invalid batch request", http.StatusBadRequest)
+		return
+	}
+
+	payload := r.Context().Value(payload("payload")).(*BatchRequest)
+
+	results := make([]BatchResult, len(payload.Requests))
+	sem := make(chan struct{}, maxConcurrentBatchRequests)
+	var wg sync.WaitGroup
+
+	for i, sub := range payload.Requests {
+		if sub.Sequential {
+			wg.Wait()
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, sub BatchSubRequest) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = a.dispatch(r, sub)
+		}(i, sub)
+	}
+	wg.Wait()
+
+	w.WriteHeader(http.StatusOK)
+	response := BatchResponse{
+		SuccessResponse: SuccessResponse{Message: "Batch completed"},
+		Results:         results,
+	}
+
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		handleError(w, err, "Could not encode response", http.StatusInternalServerError)
+	}
+}
+
+/* dispatch sends a single sub-request through the router with the credentials of the batch request */
+func (a batchService) dispatch(parent *http.Request, sub BatchSubRequest) BatchResult {
+	sr, err := http.NewRequestWithContext(context.WithValue(parent.Context(), batchKey, true), sub.Method, sub.Path, bytes.NewReader(sub.Body))
+	if err != nil {
+		return batchError(err, "Invalid batch request", http.StatusBadRequest)
+	}
+	sr.Header.Set("Authorization", parent.Header.Get("Authorization"))
+
+	rec := newResponseRecorder()
+	a.handler.ServeHTTP(&rec, sr)
+
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	body := bytes.TrimSpace(rec.body.Bytes())
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(body))
+	}
+
+	return BatchResult{Status: status, Body: body}
+}
+
+func batchError(err error, message string, status int) BatchResult {
+	response, status := newErrorResponse(err, message, status)
+	body, _ := json.Marshal(response)
+	return BatchResult{Status: status, Body: body}
+}
+
+/* responseRecorder collects a response in memory for routes that are called from inside the server */
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() responseRecorder {
+	return responseRecorder{header: http.Header{}}
+}
+
+func (w *responseRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
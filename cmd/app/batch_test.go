@@ -0,0 +1,156 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/* newBatchRouter wires up the batch endpoint the same way CreateRouter does, around the given routes */
+func newBatchRouter(m *http.ServeMux) http.Handler {
+	handler := middleware(m, withSecretCheck("s3cret"))
+	m.Handle("/batch", middleware(
+		batchService{handler},
+		withPayloadValidation(methodToPayload{http.MethodPost: newPayload[BatchRequest]}),
+		withMethodCheck(http.MethodPost),
+	))
+	return handler
+}
+
+func getBatchData(t *testing.T, handler http.Handler, requests []BatchSubRequest) []BatchResult {
+	t.Helper()
+	request := makeRequest(t, http.MethodPost, "/batch", BatchRequest{Requests: requests})
+	request.Header.Set("Authorization", "Bearer s3cret")
+	res := recordResponse(handler, request)
+	assert(t, res.status, http.StatusOK)
+
+	var data BatchResponse
+	err := json.Unmarshal(res.body.Bytes(), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, data.Message, "Batch completed")
+	return data.Results
+}
+
+func recordResponse(handler http.Handler, request *http.Request) *responseRecorder {
+	rec := newResponseRecorder()
+	handler.ServeHTTP(&rec, request)
+	return &rec
+}
+
+func TestBatchHandler(t *testing.T) {
+	t.Run("Returns results in order and isolates failures", func(t *testing.T) {
+		m := http.NewServeMux()
+		m.Handle("/mr/info", middleware(
+			infoService{testProjectData, fakeMergeRequestGetter{}},
+			withMethodCheck(http.MethodGet),
+		))
+		m.Handle("/failing", middleware(
+			infoService{testProjectData, fakeMergeRequestGetter{testBase{errFromGitlab: true}}},
+			withMethodCheck(http.MethodGet),
+		))
+		m.Handle("/echo", middleware(
+			fakeHandler{},
+			withPayloadValidation(methodToPayload{http.MethodPost: newPayload[FakePayload]}),
+			withMethodCheck(http.MethodPost),
+		))
+
+		results := getBatchData(t, newBatchRouter(m), []BatchSubRequest{
+			{Method: http.MethodGet, Path: "/failing"},
+			{Method: http.MethodGet, Path: "/mr/info"},
+			{Method: http.MethodPost, Path: "/echo", Body: json.RawMessage(`{}`)},
+			{Method: http.MethodPost, Path: "/echo", Body: json.RawMessage(`{"foo":"bar"}`)},
+			{Method: http.MethodPost, Path: "/batch", Body: json.RawMessage(`{"requests":[]}`)},
+			{Method: http.MethodPost, Path: "/batch?x=1", Body: json.RawMessage(`{"requests":[{"method":"GET","path":"/mr/info"}]}`)},
+		})
+
+		assert(t, len(results), 6)
+
+		var failure ErrorResponse
+		assert(t, results[0].Status, http.StatusInternalServerError)
+		_ = json.Unmarshal(results[0].Body, &failure)
+		checkErrorFromGitlab(t, failure, "Could not get project info")
+
+		var info InfoResponse
+		_ = json.Unmarshal(results[1].Body, &info)
+		assert(t, results[1].Status, http.StatusOK)
+		assert(t, info.Message, "Merge requests retrieved")
+
+		var invalid ErrorResponse
+		_ = json.Unmarshal(results[2].Body, &invalid)
+		assert(t, results[2].Status, http.StatusBadRequest)
+		assert(t, invalid.Details, "Foo is required")
+
+		assert(t, results[3].Status, http.StatusOK)
+		assert(t, results[4].Status, http.StatusBadRequest)
+
+		var nested ErrorResponse
+		_ = json.Unmarshal(results[5].Body, &nested)
+		assert(t, results[5].Status, http.StatusBadRequest)
+		assert(t, nested.Details, "batch requests cannot be nested")
+	})
+	t.Run("Runs independent requests concurrently", func(t *testing.T) {
+		var arrived sync.WaitGroup
+		arrived.Add(3)
+		m := http.NewServeMux()
+		m.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+			arrived.Done()
+			arrived.Wait() // Only returns once all three requests are in flight at the same time
+			fakeHandler{}.ServeHTTP(w, r)
+		})
+
+		done := make(chan []BatchResult)
+		go func() {
+			done <- getBatchData(t, newBatchRouter(m), []BatchSubRequest{
+				{Method: http.MethodGet, Path: "/slow"},
+				{Method: http.MethodGet, Path: "/slow"},
+				{Method: http.MethodGet, Path: "/slow"},
+			})
+		}()
+
+		select {
+		case results := <-done:
+			assert(t, len(results), 3)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Batch requests did not run concurrently")
+		}
+	})
+	t.Run("Waits for earlier requests before a sequential one", func(t *testing.T) {
+		var finished atomic.Int32
+		var seenBySequential int32
+		m := http.NewServeMux()
+		m.HandleFunc("/first", func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(10 * time.Millisecond)
+			finished.Add(1)
+			fakeHandler{}.ServeHTTP(w, r)
+		})
+		m.HandleFunc("/second", func(w http.ResponseWriter, r *http.Request) {
+			seenBySequential = finished.Load()
+			fakeHandler{}.ServeHTTP(w, r)
+		})
+
+		getBatchData(t, newBatchRouter(m), []BatchSubRequest{
+			{Method: http.MethodGet, Path: "/first"},
+			{Method: http.MethodGet, Path: "/first"},
+			{Method: http.MethodGet, Path: "/second", Sequential: true},
+		})
+		assert(t, seenBySequential, int32(2))
+	})
+	t.Run("Requires the secret for the batch itself", func(t *testing.T) {
+		request := makeRequest(t, http.MethodPost, "/batch", BatchRequest{Requests: []BatchSubRequest{{Method: http.MethodGet, Path: "/ping"}}})
+		data, status := getFailData(t, newBatchRouter(http.NewServeMux()), request)
+		assert(t, status, http.StatusUnauthorized)
+		assert(t, data.Message, "Unauthorized")
+	})
+	t.Run("Rejects an empty batch", func(t *testing.T) {
+		request := makeRequest(t, http.MethodPost, "/batch", BatchRequest{})
+		request.Header.Set("Authorization", "Bearer s3cret")
+		data, status := getFailData(t, newBatchRouter(http.NewServeMux()), request)
+		assert(t, status, http.StatusBadRequest)
+		assert(t, data.Message, "Invalid payload")
+	})
+}
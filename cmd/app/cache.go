@@ -0,0 +1,219 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Entries beyond this count push out the ones that were fetched longest ago */
+const maxCacheEntries = 500
+
+/* cacheRule names a read-only GitLab resource that rarely changes and how long a response for it is trusted without asking again */
+type cacheRule struct {
+	resource string
+	endpoint string
+	ttl      time.Duration
+}
+
+/*
+cacheRules lists the GitLab endpoints whose responses are cached, by their normalized path. Merge requests
+change often, so their info is only kept long enough to answer the bursts of requests the UI sends when it opens.
+*/
+var cacheRules = []cacheRule{
+	{resource: "labels", endpoint: "/projects/:id/labels", ttl: 5 * time.Minute},
+	{resource: "members", endpoint: "/projects/:id/members/all", ttl: 5 * time.Minute},
+	{resource: "user", endpoint: "/user", ttl: time.Hour},
+	{resource: "mr_info", endpoint: "/projects/:id/merge_requests/:id", ttl: 5 * time.Second},
+}
+
+type cacheEntry struct {
+	path      string
+	status    int
+	header    http.Header
+	body      []byte
+	fetchedAt time.Time
+	ttl       time.Duration
+}
+
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return now.Sub(e.fetchedAt) < e.ttl
+}
+
+func (e *cacheEntry) response(r *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.status, http.StatusText(e.status)),
+		StatusCode:    e.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       r,
+	}
+}
+
+/*
+responseCache keeps the responses of the endpoints in cacheRules. Until an entry's TTL runs out it is served
+without calling GitLab, afterwards it is revalidated with its ETag or Last-Modified date, so that an unchanged
+resource costs GitLab a 304 instead of a full response.
+*/
+type responseCache struct {
+	mu      sync.Mutex
+	rules   []cacheRule
+	entries map[string]*cacheEntry
+	now     func() time.Time
+}
+
+func newResponseCache(rules []cacheRule) *responseCache {
+	return &responseCache{rules: rules, entries: map[string]*cacheEntry{}, now: time.Now}
+}
+
+func (c *responseCache) rule(path string) (cacheRule, bool) {
+	endpoint := normalizeEndpoint(path)
+	for _, rule := range c.rules {
+		if rule.endpoint == endpoint {
+			return rule, true
+		}
+	}
+	return cacheRule{}, false
+}
+
+func (c *responseCache) get(key string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[key]
+}
+
+func (c *responseCache) put(key string, entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = entry
+	if len(c.entries) <= maxCacheEntries {
+		return
+	}
+	var oldest string
+	for k, e := range c.entries {
+		if oldest == "" || e.fetchedAt.Before(c.entries[oldest].fetchedAt) {
+			oldest = k
+		}
+	}
+	delete(c.entries, oldest)
+}
+
+/* touch restarts the TTL of an entry that GitLab confirmed to be unchanged */
+func (c *responseCache) touch(key string, header http.Header) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	refreshed := *entry
+	refreshed.fetchedAt = c.now()
+	refreshed.header = entry.header.Clone()
+	for _, name := range []string{"Etag", "Last-Modified", "Date"} {
+		if value := header.Get(name); value != "" {
+			refreshed.header.Set(name, value)
+		}
+	}
+	c.entries[key] = &refreshed
+	return &refreshed
+}
+
+/*
+invalidate drops the entries a change to path affects: the resource itself, the resources it belongs to, and
+everything below it. Updating /projects/1/merge_requests/2 through /mr/label or /mr/summary thus drops the cached
+info of that merge request, and so does adding a note to it.
+*/
+func (c *responseCache) invalidate(path string) {
+	path = strings.TrimSuffix(path, "/")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if isPathPrefix(entry.path, path) || isPathPrefix(path, entry.path) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func isPathPrefix(prefix string, path string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+/* cacheTransport answers cacheable GET requests from the cache and invalidates it when a request changes something */
+type cacheTransport struct {
+	next  http.RoundTripper
+	cache *responseCache
+}
+
+func (t cacheTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		res, err := t.next.RoundTrip(r)
+		if err == nil && res.StatusCode < 400 {
+			t.cache.invalidate(r.URL.Path)
+		}
+		return res, err
+	}
+
+	rule, ok := t.cache.rule(r.URL.EscapedPath())
+	if !ok || r.Method == http.MethodHead {
+		return t.next.RoundTrip(r)
+	}
+
+	key := r.URL.String()
+	cached := t.cache.get(key)
+	if cached != nil && cached.fresh(t.cache.now()) {
+		return cached.response(r), nil
+	}
+
+	if cached != nil {
+		r = r.Clone(r.Context())
+		if etag := cached.header.Get("Etag"); etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		if modified := cached.header.Get("Last-Modified"); modified != "" {
+			r.Header.Set("If-Modified-Since", modified)
+		}
+	}
+
+	res, err := t.next.RoundTrip(r)
+	if err != nil {
+		return res, err
+	}
+
+	if res.StatusCode == http.StatusNotModified && cached != nil {
+		_, _ = io.Copy(io.Discard, res.Body)
+		res.Body.Close() // nolint
+		if refreshed := t.cache.touch(key, res.Header); refreshed != nil {
+			return refreshed.response(r), nil
+		}
+		return cached.response(r), nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return res, nil
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close() // nolint
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	t.cache.put(key, &cacheEntry{
+		path:      strings.TrimSuffix(r.URL.Path, "/"),
+		status:    res.StatusCode,
+		header:    res.Header.Clone(),
+		body:      body,
+		fetchedAt: t.cache.now(),
+		ttl:       rule.ttl,
+	})
+	return res, nil
+}
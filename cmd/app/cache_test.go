@@ -0,0 +1,120 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/* fakeGitlab serves a versioned resource with an ETag and counts the full and the conditional requests it gets */
+type fakeGitlab struct {
+	version     atomic.Int32
+	full        atomic.Int32
+	conditional atomic.Int32
+}
+
+func (f *fakeGitlab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		f.version.Add(1)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	etag := fmt.Sprintf(`W/"%d"`, f.version.Load())
+	if r.Header.Get("If-None-Match") == etag {
+		f.conditional.Add(1)
+		w.Header().Set("Etag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	f.full.Add(1)
+	w.Header().Set("Etag", etag)
+	fmt.Fprintf(w, "version %d", f.version.Load())
+}
+
+func newCachingClient(t *testing.T) (*http.Client, *fakeGitlab, *responseCache, *time.Time) {
+	t.Helper()
+	upstream := &fakeGitlab{}
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+
+	now := time.Now()
+	cache := newResponseCache(cacheRules)
+	cache.now = func() time.Time { return now }
+	client := &http.Client{Transport: cacheTransport{rewriteHost{server.URL, http.DefaultTransport}, cache}}
+	return client, upstream, cache, &now
+}
+
+/* rewriteHost sends requests meant for GitLab to a local test server */
+type rewriteHost struct {
+	url  string
+	next http.RoundTripper
+}
+
+func (h rewriteHost) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = "http", h.url[len("http://"):]
+	return h.next.RoundTrip(r)
+}
+
+func fetchBody(t *testing.T, client *http.Client, method string, url string) string {
+	t.Helper()
+	request, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	assert(t, res.StatusCode, http.StatusOK)
+	body, _ := io.ReadAll(res.Body)
+	return string(body)
+}
+
+func TestCacheTransport(t *testing.T) {
+	labels := "https://gitlab.com/api/v4/projects/1/labels"
+	mr := "https://gitlab.com/api/v4/projects/1/merge_requests/2"
+
+	t.Run("Serves cached responses until their TTL runs out", func(t *testing.T) {
+		client, upstream, _, _ := newCachingClient(t)
+		assert(t, fetchBody(t, client, http.MethodGet, labels), "version 0")
+		assert(t, fetchBody(t, client, http.MethodGet, labels), "version 0")
+		assert(t, upstream.full.Load(), int32(1))
+		assert(t, upstream.conditional.Load(), int32(0))
+	})
+	t.Run("Revalidates expired responses with their ETag", func(t *testing.T) {
+		client, upstream, _, now := newCachingClient(t)
+		fetchBody(t, client, http.MethodGet, labels)
+		*now = now.Add(10 * time.Minute)
+
+		assert(t, fetchBody(t, client, http.MethodGet, labels), "version 0")
+		assert(t, upstream.full.Load(), int32(1))
+		assert(t, upstream.conditional.Load(), int32(1))
+
+		assert(t, fetchBody(t, client, http.MethodGet, labels), "version 0")
+		assert(t, upstream.conditional.Load(), int32(1))
+	})
+	t.Run("Invalidates the entries a change affects", func(t *testing.T) {
+		client, upstream, _, _ := newCachingClient(t)
+		fetchBody(t, client, http.MethodGet, labels)
+		fetchBody(t, client, http.MethodGet, mr)
+
+		fetchBody(t, client, http.MethodPut, mr)
+
+		assert(t, fetchBody(t, client, http.MethodGet, mr), "version 1")
+		assert(t, fetchBody(t, client, http.MethodGet, labels), "version 0")
+		assert(t, upstream.full.Load(), int32(3))
+	})
+	t.Run("Does not cache other endpoints", func(t *testing.T) {
+		client, upstream, cache, _ := newCachingClient(t)
+		fetchBody(t, client, http.MethodGet, "https://gitlab.com/api/v4/projects/1/merge_requests/2/discussions")
+		fetchBody(t, client, http.MethodGet, "https://gitlab.com/api/v4/projects/1/merge_requests/2/discussions")
+		assert(t, upstream.full.Load(), int32(2))
+		assert(t, len(cache.entries), 0)
+	})
+}
@@ -1,12 +1,10 @@
 package app
 
 import (
-	"crypto/tls"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
 
 	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
 	"github.com/hashicorp/go-retryablehttp"
@@ -47,41 +45,28 @@ func NewClient() (*Client, error) {
 		gitlab.WithBaseURL(apiCustUrl),
 	}
 
-	if pluginOptions.Debug.GitlabRequest {
-		gitlabOptions = append(gitlabOptions, gitlab.WithRequestLogHook(
-			func(l retryablehttp.Logger, r *http.Request, i int) {
-				logRequest("REQUEST TO GITLAB", r)
-			},
-		))
-	}
-
-	if pluginOptions.Debug.GitlabResponse {
-		gitlabOptions = append(gitlabOptions, gitlab.WithResponseLogHook(func(l retryablehttp.Logger, response *http.Response) {
-			logResponse("RESPONSE FROM GITLAB", response)
-		},
-		))
-	}
-
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: pluginOptions.ConnectionSettings.Insecure,
-		},
+	tr, err := newTransport()
+	if err != nil {
+		return nil, err
 	}
 
-	if proxy := pluginOptions.ConnectionSettings.Proxy; proxy != "" {
-		u, err := url.Parse(proxy)
-		if err != nil {
-			return nil, fmt.Errorf("parse proxy url: %w", err)
-		}
-		tr.Proxy = http.ProxyURL(u)
+	var next http.RoundTripper = limitTransport{loggingTransport{metricsTransport{tr, metrics}}, outbound}
+	if oauth != nil {
+		next = oauthTransport{next, oauth}
 	}
 
 	retryClient := retryablehttp.NewClient()
-	retryClient.HTTPClient.Transport = tr
+	retryClient.HTTPClient.Transport = diskCacheTransport{cacheTransport{next, newResponseCache(cacheRules)}, diskCache}
 	gitlabOptions = append(gitlabOptions, gitlab.WithHTTPClient(retryClient.HTTPClient))
-	gitlabOptions = append(gitlabOptions, gitlab.WithoutRetries())
+	gitlabOptions = append(gitlabOptions, newRetryPolicy().clientOptions()...)
+	gitlabOptions = append(gitlabOptions, gitlab.WithRequestLogHook(metrics.retryHook))
 
-	client, err := gitlab.NewClient(pluginOptions.AuthToken, gitlabOptions...)
+	var authSource gitlab.AuthSource = gitlab.AccessTokenAuthSource{Token: pluginOptions.AuthToken}
+	if oauth != nil {
+		authSource = gitlab.OAuthTokenSource{TokenSource: oauth}
+	}
+
+	client, err := gitlab.NewAuthSourceClient(authSource, gitlabOptions...)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %v", err)
@@ -103,11 +88,34 @@ func NewClient() (*Client, error) {
 	}, nil
 }
 
+/* newTransport returns the transport for calls to Gitlab, configured by the connection settings */
+func newTransport() (http.RoundTripper, error) {
+	tlsConfig, err := newTLSConfig(pluginOptions.ConnectionSettings.TransportSettings)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := newProxyFunc(pluginOptions.ConnectionSettings.TransportSettings)
+	if err != nil {
+		return nil, err
+	}
+	tr := &http.Transport{
+		TLSClientConfig: tlsConfig,
+		Proxy:           proxy,
+	}
+
+	return certificateErrorTransport{tr}, nil
+}
+
+type ProjectGetter interface {
+	GetProject(pid interface{}, opt *gitlab.GetProjectOptions, options ...gitlab.RequestOptionFunc) (*gitlab.Project, *gitlab.Response, error)
+}
+
 /* InitProjectSettings fetch the project ID using the client */
-func InitProjectSettings(c *Client, gitInfo git.GitData) (*ProjectInfo, error) {
+func InitProjectSettings(ctx context.Context, c ProjectGetter, gitInfo git.GitData) (*ProjectInfo, error) {
+	diskCache.useProject(pluginOptions.GitlabUrl, gitInfo.ProjectPath())
 
 	opt := gitlab.GetProjectOptions{}
-	project, _, err := c.GetProject(gitInfo.ProjectPath(), &opt)
+	project, _, err := c.GetProject(gitInfo.ProjectPath(), &opt, gitlab.WithContext(ctx))
 
 	if err != nil {
 		return nil, fmt.Errorf("error getting project at %s: %w", gitInfo.RemoteUrl, err)
@@ -123,17 +131,3 @@ func InitProjectSettings(c *Client, gitInfo git.GitData) (*ProjectInfo, error) {
 		ProjectId: projectId,
 	}, nil
 }
-
-/* handleError is a utililty handler that returns errors to the client along with their statuses and messages */
-func handleError(w http.ResponseWriter, err error, message string, status int) {
-	w.WriteHeader(status)
-	response := ErrorResponse{
-		Message: message,
-		Details: err.Error(),
-	}
-
-	err = json.NewEncoder(w).Encode(response)
-	if err != nil {
-		handleError(w, err, "Could not encode error response", http.StatusInternalServerError)
-	}
-}
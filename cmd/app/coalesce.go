@@ -0,0 +1,158 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+/* bufferedResponse records what a handler writes, so that it can be replayed to every request of a flight */
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) replay(w http.ResponseWriter) {
+	for name, values := range b.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(b.status)
+	_, _ = w.Write(b.body.Bytes())
+}
+
+/* flight is one run of a handler that identical requests wait for */
+type flight struct {
+	done     chan struct{}
+	response *bufferedResponse
+	stale    bool
+	waiters  int
+}
+
+/* flightGroup tracks the flights in progress by the request they answer */
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: map[string]*flight{}}
+}
+
+type coalescingMiddleware struct {
+	data
+	methods []string
+	group   *flightGroup
+}
+
+/*
+Lets identical reads that arrive while one is in progress share its calls to GitLab and its response, since the
+UI often asks for the same merge request from several places at once. Requests are identical when they have the
+same method, path and payload, and address the same merge request of the same project.
+*/
+func (m coalescingMiddleware) handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Contains(m.methods, r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := m.key(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		m.group.mu.Lock()
+		if f, ok := m.group.flights[key]; ok {
+			f.waiters++
+			m.group.mu.Unlock()
+			select {
+			case <-f.done:
+				if usage, ok := r.Context().Value(cacheUsageKey).(*cacheUsage); ok && f.stale {
+					usage.stale.Store(true)
+				}
+				f.response.replay(w)
+			case <-r.Context().Done():
+			}
+			return
+		}
+		f := &flight{done: make(chan struct{}), response: &bufferedResponse{header: http.Header{}}}
+		m.group.flights[key] = f
+		m.group.mu.Unlock()
+
+		/* The flight answers other requests too, so it must not end when this one is cancelled, only when it runs out of time */
+		ctx := context.WithoutCancel(r.Context())
+		if deadline, ok := r.Context().Deadline(); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
+
+		/* If the handler panics, the requests waiting on the flight get an error instead of waiting forever */
+		finished := false
+		defer func() {
+			if !finished {
+				failed := &bufferedResponse{header: http.Header{}}
+				handleError(failed, fmt.Errorf("handler for %s panicked", r.URL.Path), "Request failed", http.StatusInternalServerError)
+				f.response = failed
+				m.group.end(key, f)
+			}
+		}()
+
+		next.ServeHTTP(f.response, r.WithContext(ctx))
+		if usage, ok := r.Context().Value(cacheUsageKey).(*cacheUsage); ok {
+			f.stale = usage.servedStale()
+		}
+
+		if waiters := m.group.end(key, f); waiters > 0 {
+			logger.Debug("Shared response", "request_id", requestId(r.Context()), "route", r.URL.Path, "waiters", waiters)
+		}
+		finished = true
+
+		f.response.replay(w)
+	})
+}
+
+/* end removes a flight, releases the requests waiting on it and returns how many there were */
+func (g *flightGroup) end(key string, f *flight) int {
+	g.mu.Lock()
+	delete(g.flights, key)
+	waiters := f.waiters
+	g.mu.Unlock()
+	close(f.done)
+	return waiters
+}
+
+func (m coalescingMiddleware) key(r *http.Request) (string, error) {
+	body, err := json.Marshal(r.Context().Value(payload("payload")))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s?%s project=%s mr=%d %s", r.Method, r.URL.Path, r.URL.RawQuery, m.projectId(r), m.mergeId(r), body), nil
+}
+
+/* withCoalescing shares one run of a route between identical concurrent requests with the given methods, which must only read */
+func withCoalescing(d data, methods ...string) mw {
+	return coalescingMiddleware{d, methods, newFlightGroup()}.handle
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+/* gatedMergeRequestGetter counts its calls and holds them until it is released */
+type gatedMergeRequestGetter struct {
+	calls   *atomic.Int32
+	release chan struct{}
+}
+
+func (f gatedMergeRequestGetter) GetMergeRequest(pid interface{}, mergeRequest int64, opt *gitlab.GetMergeRequestsOptions, options ...gitlab.RequestOptionFunc) (*gitlab.MergeRequest, *gitlab.Response, error) {
+	f.calls.Add(1)
+	<-f.release
+	return &gitlab.MergeRequest{BasicMergeRequest: gitlab.BasicMergeRequest{IID: mergeRequest}}, makeResponse(http.StatusOK), nil
+}
+
+/* waitForWaiters blocks until the given number of requests wait for a flight */
+func waitForWaiters(t *testing.T, group *flightGroup, waiters int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		group.mu.Lock()
+		count := 0
+		for _, f := range group.flights {
+			count += f.waiters
+		}
+		group.mu.Unlock()
+		if count == waiters {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Expected %d requests to wait", waiters)
+}
+
+func TestCoalescingMiddleware(t *testing.T) {
+	newService := func() (http.Handler, gatedMergeRequestGetter, *flightGroup) {
+		client := gatedMergeRequestGetter{calls: &atomic.Int32{}, release: make(chan struct{})}
+		group := newFlightGroup()
+		svc := middleware(
+			infoService{testProjectData, client},
+			coalescingMiddleware{testProjectData, []string{http.MethodGet}, group}.handle,
+			withMethodCheck(http.MethodGet),
+		)
+		return svc, client, group
+	}
+
+	t.Run("Shares one call to GitLab between identical concurrent requests", func(t *testing.T) {
+		svc, client, group := newService()
+
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				data := getSuccessData(t, svc, makeRequest(t, http.MethodGet, "/mr/info", nil))
+				assert(t, data.Message, "Merge requests retrieved")
+			}()
+		}
+		waitForWaiters(t, group, 9)
+		close(client.release)
+		wg.Wait()
+
+		assert(t, client.calls.Load(), int32(1))
+	})
+	t.Run("Keeps requests for different merge requests apart", func(t *testing.T) {
+		svc, client, _ := newService()
+		close(client.release)
+
+		var wg sync.WaitGroup
+		for _, iid := range []int64{1, 2} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				request := makeRequest(t, http.MethodGet, "/mr/info", nil)
+				request = request.WithContext(context.WithValue(request.Context(), mergeRequestIID, iid))
+				getSuccessData(t, svc, request)
+			}()
+		}
+		wg.Wait()
+
+		assert(t, client.calls.Load(), int32(2))
+	})
+	t.Run("Calls GitLab again once a flight is over", func(t *testing.T) {
+		svc, client, _ := newService()
+		close(client.release)
+
+		getSuccessData(t, svc, makeRequest(t, http.MethodGet, "/mr/info", nil))
+		getSuccessData(t, svc, makeRequest(t, http.MethodGet, "/mr/info", nil))
+
+		assert(t, client.calls.Load(), int32(2))
+	})
+	t.Run("Keeps serving the others when the first request is cancelled", func(t *testing.T) {
+		svc, client, group := newService()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		first := make(chan struct{})
+		go func() {
+			defer close(first)
+			request := makeRequest(t, http.MethodGet, "/mr/info", nil).WithContext(ctx)
+			getSuccessData(t, svc, request)
+		}()
+		for client.calls.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		second := make(chan SuccessResponse)
+		go func() { second <- getSuccessData(t, svc, makeRequest(t, http.MethodGet, "/mr/info", nil)) }()
+		waitForWaiters(t, group, 1)
+
+		cancel()
+		close(client.release)
+		assert(t, (<-second).Message, "Merge requests retrieved")
+		<-first
+		assert(t, client.calls.Load(), int32(1))
+	})
+	t.Run("Answers the others with an error when the first request panics", func(t *testing.T) {
+		group := newFlightGroup()
+		release := make(chan struct{})
+		svc := coalescingMiddleware{testProjectData, []string{http.MethodGet}, group}.handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			panic("boom")
+		}))
+
+		first := make(chan any)
+		go func() {
+			defer func() { first <- recover() }()
+			svc.ServeHTTP(httptest.NewRecorder(), makeRequest(t, http.MethodGet, "/mr/info", nil))
+		}()
+		for {
+			group.mu.Lock()
+			started := len(group.flights) == 1
+			group.mu.Unlock()
+			if started {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		second := make(chan int)
+		go func() {
+			_, status := getFailData(t, svc, makeRequest(t, http.MethodGet, "/mr/info", nil))
+			second <- status
+		}()
+		waitForWaiters(t, group, 1)
+
+		close(release)
+		assert(t, <-second, http.StatusInternalServerError)
+		assert(t, <-first, any("boom"))
+		assert(t, len(group.flights), 0)
+	})
+}
@@ -24,6 +24,14 @@ type commentService struct {
 	client CommentManager
 }
 
+func (a commentService) describe(r *route) {
+	r.responses = methodToResponse{
+		http.MethodPost:   CommentResponse{},
+		http.MethodDelete: SuccessResponse{},
+		http.MethodPatch:  CommentResponse{},
+	}
+}
+
 /* commentHandler creates, edits, and deletes discussions (comments, multi-line comments) */
 func (a commentService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -46,7 +54,7 @@ type DeleteCommentRequest struct {
 func (a commentService) deleteComment(w http.ResponseWriter, r *http.Request) {
 	payload := r.Context().Value(payload("payload")).(*DeleteCommentRequest)
 
-	res, err := a.client.DeleteMergeRequestDiscussionNote(a.projectInfo.ProjectId, a.projectInfo.MergeId, payload.DiscussionId, payload.NoteId)
+	res, err := a.client.DeleteMergeRequestDiscussionNote(a.projectId(r), a.mergeId(r), payload.DiscussionId, payload.NoteId, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not delete comment", http.StatusInternalServerError)
@@ -97,7 +105,7 @@ func (a commentService) postComment(w http.ResponseWriter, r *http.Request) {
 		opt.Position = buildCommentPosition(commentWithPositionData)
 	}
 
-	discussion, res, err := a.client.CreateMergeRequestDiscussion(a.projectInfo.ProjectId, a.projectInfo.MergeId, &opt)
+	discussion, res, err := a.client.CreateMergeRequestDiscussion(a.projectId(r), a.mergeId(r), &opt, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not create discussion", http.StatusInternalServerError)
@@ -138,7 +146,7 @@ func (a commentService) editComment(w http.ResponseWriter, r *http.Request) {
 		Body: gitlab.Ptr(payload.Comment),
 	}
 
-	note, res, err := a.client.UpdateMergeRequestDiscussionNote(a.projectInfo.ProjectId, a.projectInfo.MergeId, payload.DiscussionId, payload.NoteId, &options)
+	note, res, err := a.client.UpdateMergeRequestDiscussionNote(a.projectId(r), a.mergeId(r), payload.DiscussionId, payload.NoteId, &options, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not update comment", http.StatusInternalServerError)
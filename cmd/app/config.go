@@ -1,31 +1,144 @@
 package app
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
 type PluginOptions struct {
 	GitlabUrl string `json:"gitlab_url"`
 	Port      int    `json:"port"`
 	AuthToken string `json:"auth_token"`
 	LogPath   string `json:"log_path"`
+	CacheDir  string `json:"cache_dir"`
+	Secret    string `json:"secret"`
+	Stdio     bool   `json:"stdio"`
 	Debug     struct {
 		Request        bool `json:"request"`
 		Response       bool `json:"response"`
 		GitlabRequest  bool `json:"gitlab_request"`
 		GitlabResponse bool `json:"gitlab_response"`
+		Pprof          bool `json:"pprof"`
 	} `json:"debug"`
-	ChosenMrIID        int64 `json:"chosen_mr_iid"`
+	ChosenMrIID        int64             `json:"chosen_mr_iid"`
+	EventsPollInterval int               `json:"events_poll_interval"`
+	ParentPid          int               `json:"parent_pid"`
+	IdleTimeout        int               `json:"idle_timeout"`
+	Timeouts           map[string]int    `json:"timeouts"`
+	Auth               AuthOptions       `json:"auth"`
+	Instances          []InstanceOptions `json:"instances"`
 	ConnectionSettings struct {
-		Proxy    string `json:"proxy"`
-		Insecure bool   `json:"insecure"`
-		Remote   string `json:"remote"`
+		TransportSettings
+		Remote            string  `json:"remote"`
+		SocketPath        string  `json:"socket_path"`
+		MaxInFlight       int     `json:"max_in_flight"`
+		RequestsPerSecond float64 `json:"requests_per_second"`
+		Retry             struct {
+			MaxAttempts  int `json:"max_attempts"`
+			MinBackoffMs int `json:"min_backoff_ms"`
+			MaxBackoffMs int `json:"max_backoff_ms"`
+		} `json:"retry"`
 	} `json:"connection_settings"`
 }
 
+/* AuthOptions tell the server where to find the auth token of a Gitlab instance */
+type AuthOptions struct {
+	TokenEnv         string   `json:"token_env"`
+	TokenFile        string   `json:"token_file"`
+	CredentialHelper bool     `json:"credential_helper"`
+	TokenCommand     []string `json:"token_command"`
+	OAuth            struct {
+		ClientId  string   `json:"client_id"`
+		Scopes    []string `json:"scopes"`
+		TokenPath string   `json:"token_path"`
+	} `json:"oauth"`
+}
+
+/* TransportSettings configure the connection to a Gitlab instance */
+type TransportSettings struct {
+	Proxy         string   `json:"proxy"`
+	Insecure      bool     `json:"insecure"`
+	CaFile        string   `json:"ca_file"`
+	ClientCert    string   `json:"client_cert"`
+	ClientKey     string   `json:"client_key"`
+	MinTlsVersion string   `json:"min_tls_version"`
+	NoProxy       []string `json:"no_proxy"`
+}
+
+/* InstanceOptions configure one of several Gitlab instances, which is picked by the host of the git remote */
+type InstanceOptions struct {
+	GitlabUrl          string            `json:"gitlab_url"`
+	RemoteHosts        []string          `json:"remote_hosts"`
+	AuthToken          string            `json:"auth_token"`
+	Auth               AuthOptions       `json:"auth"`
+	ConnectionSettings TransportSettings `json:"connection_settings"`
+}
+
 var pluginOptions PluginOptions
 var version string
 
 func SetPluginOptions(p PluginOptions) {
 	pluginOptions = p
+	setupLogger()
+	setupDiskCache()
+	setupOutboundLimiter()
 }
 
 func SetVersion(v string) {
 	version = v
 }
+
+/*
+ReadPluginOptions reads the configuration of the server from its arguments. With `--config -` it is the first
+line of stdin and with `--config <path>` the content of a file, so that the auth token does not show up in the
+process list. A single argument holding the JSON itself is still accepted.
+*/
+func ReadPluginOptions(args []string, stdin io.Reader) (PluginOptions, error) {
+	var options PluginOptions
+	if len(args) == 0 {
+		return options, errors.New("must provide server configuration")
+	}
+
+	content := []byte(args[0])
+	if args[0] == "--config" {
+		if len(args) < 2 {
+			return options, errors.New("--config needs a path, or - for stdin")
+		}
+		var err error
+		if args[1] == "-" {
+			content, err = readLine(stdin)
+		} else {
+			content, err = os.ReadFile(args[1])
+		}
+		if err != nil {
+			return options, fmt.Errorf("could not read configuration: %w", err)
+		}
+	}
+
+	err := json.Unmarshal(content, &options)
+	return options, err
+}
+
+/* readLine reads up to the first newline one byte at a time, leaving the rest of stdin to the JSON-RPC server */
+func readLine(r io.Reader) ([]byte, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return line, nil
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF && len(line) > 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
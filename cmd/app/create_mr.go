@@ -26,6 +26,10 @@ type mergeRequestCreatorService struct {
 	client MergeRequestCreator
 }
 
+func (a mergeRequestCreatorService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPost: SuccessResponse{}}
+}
+
 /* createMr creates a merge request */
 func (a mergeRequestCreatorService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
@@ -35,7 +39,7 @@ func (a mergeRequestCreatorService) ServeHTTP(w http.ResponseWriter, r *http.Req
 		Title:              &createMrRequest.Title,
 		Description:        &createMrRequest.Description,
 		TargetBranch:       &createMrRequest.TargetBranch,
-		SourceBranch:       &a.gitInfo.BranchName,
+		SourceBranch:       gitlab.Ptr(a.state.BranchName()),
 		RemoveSourceBranch: &createMrRequest.DeleteBranch,
 		Squash:             &createMrRequest.Squash,
 	}
@@ -44,7 +48,7 @@ func (a mergeRequestCreatorService) ServeHTTP(w http.ResponseWriter, r *http.Req
 		opts.TargetProjectID = gitlab.Ptr(createMrRequest.TargetProjectID)
 	}
 
-	_, res, err := a.client.CreateMergeRequest(a.projectInfo.ProjectId, &opts)
+	_, res, err := a.client.CreateMergeRequest(a.projectId(r), &opts, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not create MR", http.StatusInternalServerError)
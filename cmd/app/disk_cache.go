@@ -0,0 +1,351 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
+)
+
+/* The context key of the cacheUsage of a request to the Go server */
+const cacheUsageKey = contextKey("cache_usage")
+
+/* Responses served from the disk cache carry this header, so the UI can tell that they may be out of date */
+const cacheHeader = "X-Gitlab-Nvim-Cache"
+
+/* How long a revalidation in the background may take */
+const revalidationTimeout = 30 * time.Second
+
+/*
+persistedEndpoints are the GitLab endpoints, by normalized path, whose last responses are kept on disk: the
+project lookup, the merge request lookup by branch, labels, members and the discussions of a merge request.
+*/
+var persistedEndpoints = []string{
+	"/projects/:id",
+	"/projects/:id/merge_requests",
+	"/projects/:id/labels",
+	"/projects/:id/members/all",
+	"/projects/:id/merge_requests/:id/discussions",
+}
+
+/*
+sessionEndpoints are the persisted endpoints whose answers the session is built on. The merge request found for
+the branch is kept for the whole session, so a revalidation that changes it calls the session hooks.
+*/
+var sessionEndpoints = []string{
+	"/projects/:id/merge_requests",
+}
+
+/* cacheUsage records whether any GitLab call made for a request to the Go server was answered from the disk cache */
+type cacheUsage struct {
+	stale atomic.Bool
+}
+
+func (u *cacheUsage) servedStale() bool {
+	return u != nil && u.stale.Load()
+}
+
+type persistedResponse struct {
+	Path      string      `json:"path"`
+	Status    int         `json:"status"`
+	Header    http.Header `json:"header"`
+	Body      []byte      `json:"body"`
+	FetchedAt time.Time   `json:"fetched_at"`
+}
+
+/* projectSnapshot is the content of one cache file, holding the responses for one project by their URL */
+type projectSnapshot struct {
+	Responses map[string]persistedResponse `json:"responses"`
+}
+
+/*
+persistentCache keeps the last responses of the persistedEndpoints on disk, in one file per GitLab host and
+project path, so that a restarted server can answer right away. The first time a response is needed after a
+start it is served from disk and revalidated in the background, afterwards calls go to GitLab as usual.
+*/
+type persistentCache struct {
+	mu           sync.Mutex
+	dir          string
+	file         string
+	snapshot     projectSnapshot
+	revalidated  map[string]bool
+	revalidating sync.WaitGroup
+	sessionHooks []func()
+}
+
+var diskCache = &persistentCache{}
+
+/* setupDiskCache enables the disk cache when the plugin options name a directory for it */
+func setupDiskCache() {
+	diskCache.mu.Lock()
+	defer diskCache.mu.Unlock()
+	diskCache.dir = pluginOptions.CacheDir
+	diskCache.file = ""
+	diskCache.snapshot = projectSnapshot{}
+}
+
+func (c *persistentCache) enabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dir != ""
+}
+
+/* useProject loads the cache file of a project, which later responses are written to */
+func (c *persistentCache) useProject(gitlabUrl string, projectPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dir == "" {
+		return
+	}
+
+	host := "unknown"
+	if u, err := url.Parse(gitlabUrl); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	file := filepath.Join(c.dir, url.PathEscape(host), url.PathEscape(projectPath)+".json")
+	if file == c.file {
+		return
+	}
+
+	c.file = file
+	c.snapshot = projectSnapshot{Responses: map[string]persistedResponse{}}
+	c.revalidated = map[string]bool{}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(content, &c.snapshot); err != nil || c.snapshot.Responses == nil {
+		logger.Warn("Ignoring unreadable cache file", "file", file)
+		c.snapshot = projectSnapshot{Responses: map[string]persistedResponse{}}
+	}
+}
+
+/* OnSessionChange registers a function that is called when a revalidation changes an answer of the sessionEndpoints */
+func (c *persistentCache) OnSessionChange(f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessionHooks = append(c.sessionHooks, f)
+}
+
+/* sessionChanged calls the session hooks, outside of the lock */
+func (c *persistentCache) sessionChanged() {
+	c.mu.Lock()
+	hooks := append([]func(){}, c.sessionHooks...)
+	c.mu.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+/* stale returns the response on disk for a URL if it was not revalidated since the server started */
+func (c *persistentCache) stale(key string) (persistedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == "" || c.revalidated[key] {
+		return persistedResponse{}, false
+	}
+	response, ok := c.snapshot.Responses[key]
+	return response, ok
+}
+
+/* claimRevalidation marks a URL as revalidated, and reports whether the caller is the first to do so */
+func (c *persistentCache) claimRevalidation(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.revalidated[key] {
+		return false
+	}
+	c.revalidated[key] = true
+	return true
+}
+
+func (c *persistentCache) store(key string, response persistedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == "" {
+		return
+	}
+	c.revalidated[key] = true
+	if existing, ok := c.snapshot.Responses[key]; ok && existing.Status == response.Status && bytes.Equal(existing.Body, response.Body) {
+		return
+	}
+	c.snapshot.Responses[key] = response
+	c.write()
+}
+
+/* invalidate drops the responses that a change to path affects, like responseCache.invalidate */
+func (c *persistentCache) invalidate(path string) {
+	path = strings.TrimSuffix(path, "/")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == "" {
+		return
+	}
+	changed := false
+	for key, response := range c.snapshot.Responses {
+		if isPathPrefix(response.Path, path) || isPathPrefix(path, response.Path) {
+			delete(c.snapshot.Responses, key)
+			changed = true
+		}
+	}
+	if changed {
+		c.write()
+	}
+}
+
+/* write saves the snapshot through a temporary file, so that a crash never leaves half a file behind. Callers hold the lock. */
+func (c *persistentCache) write() {
+	content, err := json.Marshal(c.snapshot)
+	if err != nil {
+		logger.Warn("Could not encode cache file", "file", c.file, "error", err.Error())
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(c.file), 0700); err != nil {
+		logger.Warn("Could not create cache directory", "file", c.file, "error", err.Error())
+		return
+	}
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		logger.Warn("Could not write cache file", "file", c.file, "error", err.Error())
+		return
+	}
+	if err := os.Rename(tmp, c.file); err != nil {
+		logger.Warn("Could not write cache file", "file", c.file, "error", err.Error())
+	}
+}
+
+/* wait blocks until the revalidations running in the background are done */
+func (c *persistentCache) wait() {
+	c.revalidating.Wait()
+}
+
+/* diskCacheTransport serves the persistedEndpoints from the disk cache while revalidating them, and keeps the cache up to date */
+type diskCacheTransport struct {
+	next  http.RoundTripper
+	cache *persistentCache
+}
+
+func isPersisted(path string) bool {
+	return Contains(persistedEndpoints, normalizeEndpoint(path))
+}
+
+func (t diskCacheTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodGet {
+		res, err := t.next.RoundTrip(r)
+		if err == nil && res.StatusCode < 400 && r.Method != http.MethodHead {
+			t.cache.invalidate(r.URL.Path)
+		}
+		return res, err
+	}
+
+	if !isPersisted(r.URL.EscapedPath()) {
+		return t.next.RoundTrip(r)
+	}
+
+	key := r.URL.String()
+	if stale, ok := t.cache.stale(key); ok && t.cache.claimRevalidation(key) {
+		if usage, ok := r.Context().Value(cacheUsageKey).(*cacheUsage); ok {
+			usage.stale.Store(true)
+		}
+		logger.Debug("Served from disk cache", "request_id", requestId(r.Context()), "upstream", r.URL.Path, "age_s", int64(time.Since(stale.FetchedAt).Seconds()))
+
+		background := r.Clone(context.WithoutCancel(r.Context()))
+		t.cache.revalidating.Add(1)
+		go func() {
+			defer t.cache.revalidating.Done()
+			ctx, cancel := context.WithTimeout(background.Context(), revalidationTimeout)
+			defer cancel()
+			res, err := t.fetch(background.WithContext(ctx), key)
+			if err != nil {
+				logger.Warn("Could not revalidate disk cache", "upstream", r.URL.Path, "error", err.Error())
+				return
+			}
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close() // nolint
+			if err != nil {
+				return
+			}
+			if Contains(sessionEndpoints, normalizeEndpoint(r.URL.EscapedPath())) && (res.StatusCode != stale.Status || !bytes.Equal(body, stale.Body)) {
+				logger.Info("Disk cache was out of date", "request_id", requestId(r.Context()), "upstream", r.URL.Path)
+				t.cache.sessionChanged()
+			}
+		}()
+
+		header := stale.Header.Clone()
+		header.Set(cacheHeader, "stale")
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", stale.Status, http.StatusText(stale.Status)),
+			StatusCode:    stale.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(stale.Body)),
+			ContentLength: int64(len(stale.Body)),
+			Request:       r,
+		}, nil
+	}
+
+	return t.fetch(r, key)
+}
+
+/* fetch calls GitLab and writes successful responses to the disk cache */
+func (t diskCacheTransport) fetch(r *http.Request, key string) (*http.Response, error) {
+	res, err := t.next.RoundTrip(r)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close() // nolint
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	t.cache.store(key, persistedResponse{
+		Path:      strings.TrimSuffix(r.URL.Path, "/"),
+		Status:    res.StatusCode,
+		Header:    res.Header.Clone(),
+		Body:      body,
+		FetchedAt: time.Now(),
+	})
+	return res, nil
+}
+
+/*
+backgroundFetchGitManager runs `git fetch` in the background instead of making startup wait for it. It is only
+used at startup with the disk cache, whose answers may be out of date anyway until they are revalidated. Request
+handlers, such as the remote switch of /session, keep waiting for the fetch so they never read old refs.
+*/
+type backgroundFetchGitManager struct {
+	git.GitManager
+}
+
+func (g backgroundFetchGitManager) RefreshProjectInfo(remote string) error {
+	go func() {
+		if err := g.GitManager.RefreshProjectInfo(remote); err != nil {
+			logger.Warn("Could not fetch from remote", "remote", remote, "error", err.Error())
+		}
+	}()
+	return nil
+}
+
+/* NewStartupGitManager returns the git commands that startup runs, which do not wait for `git fetch` when the disk cache is enabled */
+func NewStartupGitManager() git.GitManager {
+	if diskCache.enabled() {
+		return backgroundFetchGitManager{git.Git{}}
+	}
+	return git.Git{}
+}
@@ -0,0 +1,150 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+/* newDiskCachingClient starts a fake GitLab and returns a client whose disk cache lives in dir, as after a restart */
+func newDiskCachingClient(t *testing.T, dir string, upstream *fakeGitlab) (*http.Client, *persistentCache) {
+	t.Helper()
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+
+	cache := &persistentCache{dir: dir}
+	cache.useProject("https://gitlab.com", "group/project")
+	return &http.Client{Transport: diskCacheTransport{rewriteHost{server.URL, http.DefaultTransport}, cache}}, cache
+}
+
+func getWithUsage(t *testing.T, client *http.Client, url string) (string, *cacheUsage, http.Header) {
+	t.Helper()
+	usage := &cacheUsage{}
+	request, err := http.NewRequestWithContext(context.WithValue(context.Background(), cacheUsageKey, usage), http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body := make([]byte, 64)
+	n, _ := res.Body.Read(body)
+	return string(body[:n]), usage, res.Header
+}
+
+func TestDiskCacheTransport(t *testing.T) {
+	labels := "https://gitlab.com/api/v4/projects/1/labels"
+	discussions := "https://gitlab.com/api/v4/projects/1/merge_requests/2/discussions"
+
+	t.Run("Serves the last response after a restart and revalidates it in the background", func(t *testing.T) {
+		dir := t.TempDir()
+		upstream := &fakeGitlab{}
+		client, _ := newDiskCachingClient(t, dir, upstream)
+		body, usage, _ := getWithUsage(t, client, labels)
+		assert(t, body, "version 0")
+		assert(t, usage.servedStale(), false)
+
+		upstream.version.Add(1)
+		client, cache := newDiskCachingClient(t, dir, upstream)
+		body, usage, header := getWithUsage(t, client, labels)
+		assert(t, body, "version 0")
+		assert(t, usage.servedStale(), true)
+		assert(t, header.Get(cacheHeader), "stale")
+
+		cache.wait()
+		assert(t, upstream.full.Load(), int32(2))
+		body, usage, _ = getWithUsage(t, client, labels)
+		assert(t, body, "version 1")
+		assert(t, usage.servedStale(), false)
+
+		client, _ = newDiskCachingClient(t, dir, upstream)
+		body, _, _ = getWithUsage(t, client, labels)
+		assert(t, body, "version 1")
+	})
+	t.Run("Keeps projects apart", func(t *testing.T) {
+		dir := t.TempDir()
+		client, _ := newDiskCachingClient(t, dir, &fakeGitlab{})
+		getWithUsage(t, client, labels)
+
+		client, cache := newDiskCachingClient(t, dir, &fakeGitlab{})
+		cache.useProject("https://gitlab.com", "group/other-project")
+		_, usage, _ := getWithUsage(t, client, labels)
+		assert(t, usage.servedStale(), false)
+	})
+	t.Run("Drops responses that a change affects", func(t *testing.T) {
+		dir := t.TempDir()
+		client, cache := newDiskCachingClient(t, dir, &fakeGitlab{})
+		getWithUsage(t, client, labels)
+		getWithUsage(t, client, discussions)
+
+		request, _ := http.NewRequest(http.MethodPost, discussions, nil)
+		res, err := client.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		assert(t, len(cache.snapshot.Responses), 1)
+
+		client, _ = newDiskCachingClient(t, dir, &fakeGitlab{})
+		_, usage, _ := getWithUsage(t, client, discussions)
+		assert(t, usage.servedStale(), false)
+	})
+	t.Run("Tells the session when the merge request of a branch changed on revalidation", func(t *testing.T) {
+		dir := t.TempDir()
+		lookup := "https://gitlab.com/api/v4/projects/1/merge_requests?source_branch=feature"
+		upstream := &fakeGitlab{}
+		client, _ := newDiskCachingClient(t, dir, upstream)
+		getWithUsage(t, client, lookup)
+
+		client, cache := newDiskCachingClient(t, dir, upstream)
+		var changes atomic.Int32
+		cache.OnSessionChange(func() { changes.Add(1) })
+		body, usage, _ := getWithUsage(t, client, lookup)
+		cache.wait()
+		assert(t, body, "version 0")
+		assert(t, usage.servedStale(), true)
+		assert(t, changes.Load(), int32(0))
+
+		upstream.version.Add(1)
+		client, cache = newDiskCachingClient(t, dir, upstream)
+		cache.OnSessionChange(func() { changes.Add(1) })
+		getWithUsage(t, client, lookup)
+		cache.wait()
+		assert(t, changes.Load(), int32(1))
+	})
+	t.Run("Leaves the session alone when other responses change on revalidation", func(t *testing.T) {
+		dir := t.TempDir()
+		upstream := &fakeGitlab{}
+		client, _ := newDiskCachingClient(t, dir, upstream)
+		getWithUsage(t, client, labels)
+
+		upstream.version.Add(1)
+		client, cache := newDiskCachingClient(t, dir, upstream)
+		var changes atomic.Int32
+		cache.OnSessionChange(func() { changes.Add(1) })
+		getWithUsage(t, client, labels)
+		cache.wait()
+		assert(t, changes.Load(), int32(0))
+	})
+	t.Run("Does nothing without a cache directory", func(t *testing.T) {
+		client, cache := newDiskCachingClient(t, "", &fakeGitlab{})
+		getWithUsage(t, client, labels)
+		_, usage, _ := getWithUsage(t, client, labels)
+		assert(t, usage.servedStale(), false)
+		assert(t, cache.file, "")
+	})
+}
+
+func TestLoggingServerMarksCachedResponses(t *testing.T) {
+	server := LoggingServer{handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Context().Value(cacheUsageKey).(*cacheUsage).stale.Store(true)
+		fakeHandler{}.ServeHTTP(w, r)
+	})}
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, makeRequest(t, http.MethodGet, "/mr/label", nil))
+	assert(t, res.Header().Get(cacheHeader), "stale")
+}
@@ -17,6 +17,10 @@ type draftNotePublisherService struct {
 	client DraftNotePublisher
 }
 
+func (a draftNotePublisherService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPost: SuccessResponse{}}
+}
+
 type DraftNotePublishRequest struct {
 	Note int64 `json:"note,omitempty"`
 }
@@ -27,9 +31,9 @@ func (a draftNotePublisherService) ServeHTTP(w http.ResponseWriter, r *http.Requ
 	var res *gitlab.Response
 	var err error
 	if payload.Note != 0 {
-		res, err = a.client.PublishDraftNote(a.projectInfo.ProjectId, a.projectInfo.MergeId, payload.Note)
+		res, err = a.client.PublishDraftNote(a.projectId(r), a.mergeId(r), payload.Note, gitlab.WithContext(r.Context()))
 	} else {
-		res, err = a.client.PublishAllDraftNotes(a.projectInfo.ProjectId, a.projectInfo.MergeId)
+		res, err = a.client.PublishAllDraftNotes(a.projectId(r), a.mergeId(r), gitlab.WithContext(r.Context()))
 	}
 
 	if err != nil {
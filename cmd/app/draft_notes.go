@@ -40,6 +40,15 @@ type draftNoteService struct {
 	client DraftNoteManager
 }
 
+func (a draftNoteService) describe(r *route) {
+	r.responses = methodToResponse{
+		http.MethodGet:    ListDraftNotesResponse{},
+		http.MethodPost:   DraftNoteResponse{},
+		http.MethodPatch:  DraftNoteResponse{},
+		http.MethodDelete: SuccessResponse{},
+	}
+}
+
 /* draftNoteHandler creates, edits, and deletes draft notes */
 func (a draftNoteService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -64,7 +73,7 @@ type ListDraftNotesResponse struct {
 func (a draftNoteService) listDraftNotes(w http.ResponseWriter, r *http.Request) {
 
 	opt := gitlab.ListDraftNotesOptions{}
-	draftNotes, res, err := a.client.ListDraftNotes(a.projectInfo.ProjectId, a.projectInfo.MergeId, &opt)
+	draftNotes, res, err := a.client.ListDraftNotes(a.projectId(r), a.mergeId(r), &opt, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not get draft notes", http.StatusInternalServerError)
@@ -112,7 +121,7 @@ func (a draftNoteService) postDraftNote(w http.ResponseWriter, r *http.Request)
 		opt.Position = buildCommentPosition(draftNoteWithPosition)
 	}
 
-	draftNote, res, err := a.client.CreateDraftNote(a.projectInfo.ProjectId, a.projectInfo.MergeId, &opt)
+	draftNote, res, err := a.client.CreateDraftNote(a.projectId(r), a.mergeId(r), &opt, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not create draft note", http.StatusInternalServerError)
@@ -145,7 +154,7 @@ func (a draftNoteService) deleteDraftNote(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	res, err := a.client.DeleteDraftNote(a.projectInfo.ProjectId, a.projectInfo.MergeId, id)
+	res, err := a.client.DeleteDraftNote(a.projectId(r), a.mergeId(r), id, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not delete draft note", http.StatusInternalServerError)
@@ -192,7 +201,7 @@ func (a draftNoteService) updateDraftNote(w http.ResponseWriter, r *http.Request
 		Position: &payload.Position,
 	}
 
-	draftNote, res, err := a.client.UpdateDraftNote(a.projectInfo.ProjectId, a.projectInfo.MergeId, id, &opt)
+	draftNote, res, err := a.client.UpdateDraftNote(a.projectId(r), a.mergeId(r), id, &opt, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not update draft note", http.StatusInternalServerError)
@@ -48,6 +48,10 @@ type emojiService struct {
 	client EmojiManager
 }
 
+func (a emojiService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPost: CreateEmojiResponse{}, http.MethodDelete: SuccessResponse{}}
+}
+
 func (a emojiService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	switch r.Method {
@@ -81,7 +85,7 @@ func (a emojiService) deleteEmojiFromNote(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	res, err := a.client.DeleteMergeRequestAwardEmojiOnNote(a.projectInfo.ProjectId, a.projectInfo.MergeId, noteId, awardableId)
+	res, err := a.client.DeleteMergeRequestAwardEmojiOnNote(a.projectId(r), a.mergeId(r), noteId, awardableId, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not delete awardable", http.StatusInternalServerError)
@@ -120,9 +124,9 @@ func (a emojiService) postEmojiOnNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	awardEmoji, res, err := a.client.CreateMergeRequestAwardEmojiOnNote(a.projectInfo.ProjectId, a.projectInfo.MergeId, emojiPost.NoteId, &gitlab.CreateAwardEmojiOptions{
+	awardEmoji, res, err := a.client.CreateMergeRequestAwardEmojiOnNote(a.projectId(r), a.mergeId(r), emojiPost.NoteId, &gitlab.CreateAwardEmojiOptions{
 		Name: emojiPost.Emoji,
-	})
+	}, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not post emoji", http.StatusInternalServerError)
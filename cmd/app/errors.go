@@ -0,0 +1,149 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+/* ErrorCode tells clients what went wrong without matching on messages. The values are stable. */
+type ErrorCode string
+
+const (
+	CodeMrNotFound          ErrorCode = "MR_NOT_FOUND"
+	CodeMultipleMrs         ErrorCode = "MULTIPLE_MRS"
+	CodeGitlabUnauthorized  ErrorCode = "GITLAB_UNAUTHORIZED"
+	CodeGitlabForbidden     ErrorCode = "GITLAB_FORBIDDEN"
+	CodeGitlabNotFound      ErrorCode = "GITLAB_NOT_FOUND"
+	CodeGitlabRateLimited   ErrorCode = "GITLAB_RATE_LIMITED"
+	CodeGitlabError         ErrorCode = "GITLAB_ERROR"
+	CodeUpstreamTimeout     ErrorCode = "UPSTREAM_TIMEOUT"
+	CodeUpstreamUnreachable ErrorCode = "UPSTREAM_UNREACHABLE"
+	CodeValidationFailed    ErrorCode = "VALIDATION_FAILED"
+	CodeInvalidRequest      ErrorCode = "INVALID_REQUEST"
+	CodeMethodNotAllowed    ErrorCode = "METHOD_NOT_ALLOWED"
+	CodeUnauthorized        ErrorCode = "UNAUTHORIZED"
+	CodeNotFound            ErrorCode = "NOT_FOUND"
+	CodeInternal            ErrorCode = "INTERNAL"
+)
+
+/* FieldError describes one field of a payload that failed validation */
+type FieldError struct {
+	Field   string `json:"field"`
+	Tag     string `json:"tag"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+/* codedError is implemented by errors that know their code, which takes precedence over the one derived from the status */
+type codedError interface {
+	errorCode() ErrorCode
+}
+
+/* validationError lists the fields of a payload that failed validation */
+type validationError struct {
+	fields []FieldError
+}
+
+func (e validationError) Error() string {
+	message := ""
+	for i, field := range e.fields {
+		if i > 0 {
+			message += "; "
+		}
+		message += field.Message
+	}
+	return message
+}
+
+func (e validationError) errorCode() ErrorCode {
+	return CodeValidationFailed
+}
+
+/* gitlabErrorCode maps the status of a failed call to GitLab to a code */
+func gitlabErrorCode(status int) ErrorCode {
+	switch status {
+	case http.StatusUnauthorized:
+		return CodeGitlabUnauthorized
+	case http.StatusForbidden:
+		return CodeGitlabForbidden
+	case http.StatusNotFound:
+		return CodeGitlabNotFound
+	case http.StatusTooManyRequests:
+		return CodeGitlabRateLimited
+	}
+	return CodeGitlabError
+}
+
+/* statusErrorCode maps the status of a response of the Go server to a code, for errors that did not come from GitLab */
+func statusErrorCode(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	}
+	return CodeInternal
+}
+
+/*
+newErrorResponse describes an error for clients. Only errors from the GitLab client carry its status and message,
+the code of other errors, such as the GenericError that handlers return for unexpected responses, comes from the
+status the handler chose. Validation errors carry their fields, and a route that ran out of time waiting for
+GitLab always answers with a 504 and UPSTREAM_TIMEOUT, whatever status the handler chose, so that a slow GitLab
+is told apart from a failing one.
+*/
+func newErrorResponse(err error, message string, status int) (ErrorResponse, int) {
+	response := ErrorResponse{
+		Message: message,
+		Details: err.Error(),
+	}
+
+	var gitlabErr *gitlab.ErrorResponse
+	if errors.As(err, &gitlabErr) && gitlabErr.Response != nil {
+		response.GitlabStatus = gitlabErr.Response.StatusCode
+		response.GitlabMessage = gitlabErr.Message
+	}
+
+	var validationErr validationError
+	if errors.As(err, &validationErr) {
+		response.Fields = validationErr.fields
+	}
+
+	var coded codedError
+	var urlErr *url.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+		response.Code = CodeUpstreamTimeout
+	case errors.As(err, &coded) && coded.errorCode() != "":
+		response.Code = coded.errorCode()
+	case response.GitlabStatus != 0:
+		response.Code = gitlabErrorCode(response.GitlabStatus)
+	case errors.As(err, &urlErr):
+		response.Code = CodeUpstreamUnreachable
+	default:
+		response.Code = statusErrorCode(status)
+	}
+
+	return response, status
+}
+
+/* handleError is a utility handler that returns errors to the client along with their statuses, codes and messages */
+func handleError(w http.ResponseWriter, err error, message string, status int) {
+	response, status := newErrorResponse(err, message, status)
+	w.WriteHeader(status)
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		handleError(w, err, "Could not encode error response", http.StatusInternalServerError)
+	}
+}
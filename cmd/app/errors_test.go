@@ -0,0 +1,64 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func gitlabErrorWithStatus(status int, message string) error {
+	return &gitlab.ErrorResponse{
+		Response: &http.Response{StatusCode: status, Request: &http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/api/v4/projects/1"}}},
+		Message:  message,
+	}
+}
+
+func TestNewErrorResponse(t *testing.T) {
+	t.Run("Carries the status and message of errors from Gitlab", func(t *testing.T) {
+		tests := []struct {
+			status int
+			code   ErrorCode
+		}{
+			{http.StatusUnauthorized, CodeGitlabUnauthorized},
+			{http.StatusForbidden, CodeGitlabForbidden},
+			{http.StatusNotFound, CodeGitlabNotFound},
+			{http.StatusTooManyRequests, CodeGitlabRateLimited},
+			{http.StatusInternalServerError, CodeGitlabError},
+		}
+		for _, tt := range tests {
+			err := fmt.Errorf("wrapped: %w", gitlabErrorWithStatus(tt.status, "{message: 401 Unauthorized}"))
+			response, status := newErrorResponse(err, "Could not get info", http.StatusInternalServerError)
+			assert(t, status, http.StatusInternalServerError)
+			assert(t, response.Code, tt.code)
+			assert(t, response.GitlabStatus, tt.status)
+			assert(t, response.GitlabMessage, "{message: 401 Unauthorized}")
+		}
+	})
+	t.Run("Answers with a 504 when Gitlab did not respond in time", func(t *testing.T) {
+		err := &url.Error{Op: "Get", URL: "https://gitlab.com/api/v4/projects/1", Err: context.DeadlineExceeded}
+		response, status := newErrorResponse(err, "Could not get info", http.StatusInternalServerError)
+		assert(t, status, http.StatusGatewayTimeout)
+		assert(t, response.Code, CodeUpstreamTimeout)
+	})
+	t.Run("Tells when Gitlab could not be reached", func(t *testing.T) {
+		err := &url.Error{Op: "Get", URL: "https://gitlab.com/api/v4/projects/1", Err: errors.New("connection refused")}
+		response, _ := newErrorResponse(err, "Could not get info", http.StatusInternalServerError)
+		assert(t, response.Code, CodeUpstreamUnreachable)
+	})
+	t.Run("Derives the code of other errors from the status", func(t *testing.T) {
+		response, _ := newErrorResponse(errors.New("bad json"), "Could not read request body", http.StatusBadRequest)
+		assert(t, response.Code, CodeInvalidRequest)
+		assert(t, response.GitlabStatus, 0)
+	})
+	t.Run("Leaves the Gitlab status out of errors that did not come from Gitlab", func(t *testing.T) {
+		response, status := newErrorResponse(GenericError{"/pipeline"}, "No pipeline found for main branch", http.StatusInternalServerError)
+		assert(t, status, http.StatusInternalServerError)
+		assert(t, response.Code, CodeInternal)
+		assert(t, response.GitlabStatus, 0)
+	})
+}
@@ -0,0 +1,425 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+const defaultEventPollInterval = 30 * time.Second
+
+type EventType string
+
+const (
+	EventMergeRequestUpdated   EventType = "mr_updated"
+	EventNoteAdded             EventType = "note_added"
+	EventDiscussionResolved    EventType = "discussion_resolved"
+	EventDiscussionUnresolved  EventType = "discussion_unresolved"
+	EventPipelineStatusChanged EventType = "pipeline_status_changed"
+	EventApprovalChanged       EventType = "approval_changed"
+	EventBranchChanged         EventType = "branch_changed"
+)
+
+/* MergeRequestEvent is a single change to the current merge request, sent to subscribers of the /events stream */
+type MergeRequestEvent struct {
+	Type EventType `json:"type"`
+	Data any       `json:"data"`
+}
+
+type NoteAddedEvent struct {
+	DiscussionId string       `json:"discussion_id"`
+	Note         *gitlab.Note `json:"note"`
+}
+
+type DiscussionResolvedEvent struct {
+	DiscussionId string `json:"discussion_id"`
+	Resolved     bool   `json:"resolved"`
+}
+
+type PipelineStatusChangedEvent struct {
+	PipelineId     int64  `json:"pipeline_id"`
+	PreviousStatus string `json:"previous_status"`
+	Status         string `json:"status"`
+}
+
+type ApprovalChangedEvent struct {
+	Approved   bool     `json:"approved"`
+	ApprovedBy []string `json:"approved_by"`
+}
+
+type MergeRequestUpdatedEvent struct {
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+type MergeRequestWatcher interface {
+	MergeRequestLister
+	GetMergeRequest(pid interface{}, mergeRequest int64, opt *gitlab.GetMergeRequestsOptions, options ...gitlab.RequestOptionFunc) (*gitlab.MergeRequest, *gitlab.Response, error)
+	ListMergeRequestDiscussions(pid interface{}, mergeRequest int64, opt *gitlab.ListMergeRequestDiscussionsOptions, options ...gitlab.RequestOptionFunc) ([]*gitlab.Discussion, *gitlab.Response, error)
+	GetConfiguration(pid interface{}, mr int64, options ...gitlab.RequestOptionFunc) (*gitlab.MergeRequestApprovals, *gitlab.Response, error)
+}
+
+/* mrSnapshot is the state of the current merge request that is compared between polls in order to detect changes */
+type mrSnapshot struct {
+	mergeId        int64
+	updatedAt      *time.Time
+	notes          map[int64]NoteAddedEvent
+	resolved       map[string]bool
+	approved       bool
+	approvedBy     []string
+	pipelineId     int64
+	pipelineStatus string
+}
+
+/*
+eventBroker polls GitLab for the current merge request and fans out the differences between
+consecutive snapshots to every subscriber of the /events endpoint. Polling only starts once
+the first subscriber connects, and is skipped while nobody is listening. While the branch has
+no merge request, every poll looks for one again, so a stream opened before the merge request
+is created starts sending events once it exists. When the first subscriber connects after nobody
+was listening, the snapshot is taken again right away, so that the changes made while nobody was
+listening are not sent as events.
+*/
+type eventBroker struct {
+	data
+	client   MergeRequestWatcher
+	interval time.Duration
+
+	mu          sync.Mutex
+	subscribers map[chan MergeRequestEvent]struct{}
+	previous    *mrSnapshot
+	start       sync.Once
+	refresh     chan struct{}
+	done        chan struct{}
+	stopped     bool
+}
+
+func newEventBroker(d data, client MergeRequestWatcher, interval time.Duration) *eventBroker {
+	if interval <= 0 {
+		interval = defaultEventPollInterval
+	}
+	return &eventBroker{
+		data:        d,
+		client:      client,
+		interval:    interval,
+		subscribers: map[chan MergeRequestEvent]struct{}{},
+		refresh:     make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+}
+
+/* subscribe registers a new listener. The returned channel is closed when the broker stops. */
+func (b *eventBroker) subscribe() chan MergeRequestEvent {
+	ch := make(chan MergeRequestEvent, 16)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped {
+		close(ch)
+		return ch
+	}
+	if len(b.subscribers) == 0 {
+		b.previous = nil
+		select {
+		case b.refresh <- struct{}{}:
+		default:
+		}
+	}
+	b.subscribers[ch] = struct{}{}
+	b.start.Do(func() { go b.run() })
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(ch chan MergeRequestEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+/* stop ends the poller and closes every subscription, so that open streams return and the server can shut down */
+func (b *eventBroker) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped {
+		return
+	}
+	b.stopped = true
+	close(b.done)
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+/* publish sends an event that was not found by polling, such as a branch change, to every subscriber */
+func (b *eventBroker) publish(event MergeRequestEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+/* reset drops the last snapshot when the session changes, so that a different merge request is never diffed against it */
+func (b *eventBroker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.previous = nil
+}
+
+func (b *eventBroker) run() {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-b.refresh:
+			b.poll()
+		case <-ticker.C:
+			b.poll()
+		}
+	}
+}
+
+/* poll takes a new snapshot and publishes the differences from the previous one. Errors are retried on the next tick. */
+func (b *eventBroker) poll() {
+	b.mu.Lock()
+	listening := len(b.subscribers) > 0
+	b.mu.Unlock()
+
+	if !listening {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRouteTimeout)
+	defer cancel()
+	mr, err := b.state.ResolveMergeRequest(func(query mergeIdQuery) (int64, error) { return findMergeId(ctx, b.client, query) })
+	if err != nil {
+		return
+	}
+	current, err := b.takeSnapshot(ctx, mr.projectId, mr.mergeId)
+	if err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	events := diffSnapshots(b.previous, current)
+	b.previous = current
+	for _, event := range events {
+		for ch := range b.subscribers {
+			select {
+			case ch <- event:
+			default: // Drop events for subscribers that are not keeping up rather than blocking the poller
+			}
+		}
+	}
+}
+
+func (b *eventBroker) takeSnapshot(ctx context.Context, projectId string, mergeId int64) (*mrSnapshot, error) {
+	mr, res, err := b.client.GetMergeRequest(projectId, mergeId, &gitlab.GetMergeRequestsOptions{}, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		return nil, GenericError{"/events"}
+	}
+
+	discussions, err := b.listDiscussions(ctx, projectId, mergeId)
+	if err != nil {
+		return nil, err
+	}
+
+	approvals, res, err := b.client.GetConfiguration(projectId, mergeId, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		return nil, GenericError{"/events"}
+	}
+
+	snapshot := &mrSnapshot{
+		mergeId:   mergeId,
+		updatedAt: mr.UpdatedAt,
+		notes:     map[int64]NoteAddedEvent{},
+		resolved:  map[string]bool{},
+		approved:  approvals.Approved,
+	}
+
+	for _, discussion := range discussions {
+		resolvable := false
+		resolved := true
+		for _, note := range discussion.Notes {
+			snapshot.notes[note.ID] = NoteAddedEvent{DiscussionId: discussion.ID, Note: note}
+			if note.Resolvable {
+				resolvable = true
+				resolved = resolved && note.Resolved
+			}
+		}
+		if resolvable {
+			snapshot.resolved[discussion.ID] = resolved
+		}
+	}
+
+	for _, approver := range approvals.ApprovedBy {
+		if approver.User != nil {
+			snapshot.approvedBy = append(snapshot.approvedBy, approver.User.Username)
+		}
+	}
+	sort.Strings(snapshot.approvedBy)
+
+	if mr.HeadPipeline != nil {
+		snapshot.pipelineId = mr.HeadPipeline.ID
+		snapshot.pipelineStatus = mr.HeadPipeline.Status
+	}
+
+	return snapshot, nil
+}
+
+/* listDiscussions fetches every page of discussions, since GitLab returns at most 100 per page */
+func (b *eventBroker) listDiscussions(ctx context.Context, projectId string, mergeId int64) ([]*gitlab.Discussion, error) {
+	options := &gitlab.ListMergeRequestDiscussionsOptions{
+		ListOptions: gitlab.ListOptions{Page: 1, PerPage: 100},
+	}
+
+	var discussions []*gitlab.Discussion
+	for {
+		page, res, err := b.client.ListMergeRequestDiscussions(projectId, mergeId, options, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode >= 300 {
+			return nil, GenericError{"/events"}
+		}
+		discussions = append(discussions, page...)
+		if res.NextPage == 0 {
+			return discussions, nil
+		}
+		options.Page = res.NextPage
+	}
+}
+
+/*
+diffSnapshots returns the events that turn the previous snapshot into the current one. The first snapshot,
+and the first one after the merge request changes, only establish a baseline and produce no events.
+*/
+func diffSnapshots(previous *mrSnapshot, current *mrSnapshot) []MergeRequestEvent {
+	if previous == nil || previous.mergeId != current.mergeId {
+		return nil
+	}
+
+	var events []MergeRequestEvent
+
+	if !timesEqual(previous.updatedAt, current.updatedAt) {
+		events = append(events, MergeRequestEvent{EventMergeRequestUpdated, MergeRequestUpdatedEvent{UpdatedAt: current.updatedAt}})
+	}
+
+	var added []int64
+	for id := range current.notes {
+		if _, seen := previous.notes[id]; !seen {
+			added = append(added, id)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i] < added[j] })
+	for _, id := range added {
+		events = append(events, MergeRequestEvent{EventNoteAdded, current.notes[id]})
+	}
+
+	var discussionIds []string
+	for id := range current.resolved {
+		discussionIds = append(discussionIds, id)
+	}
+	sort.Strings(discussionIds)
+	for _, id := range discussionIds {
+		wasResolved, existed := previous.resolved[id]
+		isResolved := current.resolved[id]
+		if existed && wasResolved != isResolved {
+			eventType := EventDiscussionUnresolved
+			if isResolved {
+				eventType = EventDiscussionResolved
+			}
+			events = append(events, MergeRequestEvent{eventType, DiscussionResolvedEvent{DiscussionId: id, Resolved: isResolved}})
+		}
+	}
+
+	if previous.pipelineId != current.pipelineId || previous.pipelineStatus != current.pipelineStatus {
+		events = append(events, MergeRequestEvent{EventPipelineStatusChanged, PipelineStatusChangedEvent{
+			PipelineId:     current.pipelineId,
+			PreviousStatus: previous.pipelineStatus,
+			Status:         current.pipelineStatus,
+		}})
+	}
+
+	if previous.approved != current.approved || fmt.Sprint(previous.approvedBy) != fmt.Sprint(current.approvedBy) {
+		events = append(events, MergeRequestEvent{EventApprovalChanged, ApprovalChangedEvent{
+			Approved:   current.approved,
+			ApprovedBy: current.approvedBy,
+		}})
+	}
+
+	return events
+}
+
+func timesEqual(a *time.Time, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+type eventsService struct {
+	data
+	broker *eventBroker
+}
+
+func (a eventsService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodGet: eventStream{MergeRequestEvent{}}}
+}
+
+/* eventsHandler streams changes to the current merge request as server-sent events until the client disconnects */
+func (a eventsService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		handleError(w, fmt.Errorf("response writer does not support flushing"), "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events := a.broker.subscribe()
+	defer a.broker.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			j, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, j)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+type fakeMergeRequestWatcher struct {
+	testBase
+	mu             sync.Mutex
+	mergeRequests  []*gitlab.BasicMergeRequest
+	discussions    []*gitlab.Discussion
+	pipelineStatus string
+	approvedBy     []string
+}
+
+func (f *fakeMergeRequestWatcher) ListProjectMergeRequests(pid interface{}, opt *gitlab.ListProjectMergeRequestsOptions, options ...gitlab.RequestOptionFunc) ([]*gitlab.BasicMergeRequest, *gitlab.Response, error) {
+	resp, err := f.handleGitlabError()
+	if err != nil {
+		return nil, nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.mergeRequests, resp, nil
+}
+
+func (f *fakeMergeRequestWatcher) GetMergeRequest(pid interface{}, mergeRequest int64, opt *gitlab.GetMergeRequestsOptions, options ...gitlab.RequestOptionFunc) (*gitlab.MergeRequest, *gitlab.Response, error) {
+	resp, err := f.handleGitlabError()
+	if err != nil {
+		return nil, nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	mr := &gitlab.MergeRequest{}
+	mr.HeadPipeline = &gitlab.Pipeline{ID: 1, Status: f.pipelineStatus}
+	return mr, resp, nil
+}
+
+func (f *fakeMergeRequestWatcher) ListMergeRequestDiscussions(pid interface{}, mergeRequest int64, opt *gitlab.ListMergeRequestDiscussionsOptions, options ...gitlab.RequestOptionFunc) ([]*gitlab.Discussion, *gitlab.Response, error) {
+	resp, err := f.handleGitlabError()
+	if err != nil {
+		return nil, nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	start := int((opt.Page - 1) * opt.PerPage)
+	end := min(start+int(opt.PerPage), len(f.discussions))
+	if end < len(f.discussions) {
+		resp.NextPage = opt.Page + 1
+	}
+	return f.discussions[min(start, end):end], resp, nil
+}
+
+func (f *fakeMergeRequestWatcher) GetConfiguration(pid interface{}, mr int64, options ...gitlab.RequestOptionFunc) (*gitlab.MergeRequestApprovals, *gitlab.Response, error) {
+	resp, err := f.handleGitlabError()
+	if err != nil {
+		return nil, nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	approvals := &gitlab.MergeRequestApprovals{Approved: len(f.approvedBy) > 0}
+	for _, username := range f.approvedBy {
+		approvals.ApprovedBy = append(approvals.ApprovedBy, &gitlab.MergeRequestApproverUser{User: &gitlab.BasicUser{Username: username}})
+	}
+	return approvals, resp, nil
+}
+
+func (f *fakeMergeRequestWatcher) addNote(discussionId string, noteId int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.discussions = append(f.discussions, &gitlab.Discussion{ID: discussionId, Notes: []*gitlab.Note{{ID: noteId}}})
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	base := func() *mrSnapshot {
+		return &mrSnapshot{
+			mergeId:        10,
+			notes:          map[int64]NoteAddedEvent{1: {DiscussionId: "abc"}},
+			resolved:       map[string]bool{"abc": false},
+			pipelineId:     1,
+			pipelineStatus: "running",
+		}
+	}
+
+	t.Run("The first snapshot only sets a baseline", func(t *testing.T) {
+		assert(t, len(diffSnapshots(nil, base())), 0)
+	})
+	t.Run("Switching merge requests only sets a baseline", func(t *testing.T) {
+		current := base()
+		current.mergeId = 11
+		current.pipelineStatus = "success"
+		assert(t, len(diffSnapshots(base(), current)), 0)
+	})
+	t.Run("Reports nothing when nothing changed", func(t *testing.T) {
+		assert(t, len(diffSnapshots(base(), base())), 0)
+	})
+	t.Run("Reports added notes", func(t *testing.T) {
+		current := base()
+		current.notes[2] = NoteAddedEvent{DiscussionId: "def", Note: &gitlab.Note{ID: 2}}
+		events := diffSnapshots(base(), current)
+		assert(t, len(events), 1)
+		assert(t, events[0].Type, EventNoteAdded)
+		assert(t, events[0].Data.(NoteAddedEvent).DiscussionId, "def")
+	})
+	t.Run("Reports resolved and unresolved discussions", func(t *testing.T) {
+		current := base()
+		current.resolved["abc"] = true
+		events := diffSnapshots(base(), current)
+		assert(t, len(events), 1)
+		assert(t, events[0].Type, EventDiscussionResolved)
+
+		events = diffSnapshots(current, base())
+		assert(t, len(events), 1)
+		assert(t, events[0].Type, EventDiscussionUnresolved)
+	})
+	t.Run("Reports pipeline status changes", func(t *testing.T) {
+		current := base()
+		current.pipelineStatus = "failed"
+		events := diffSnapshots(base(), current)
+		assert(t, len(events), 1)
+		assert(t, events[0].Type, EventPipelineStatusChanged)
+		assert(t, events[0].Data.(PipelineStatusChangedEvent).PreviousStatus, "running")
+		assert(t, events[0].Data.(PipelineStatusChangedEvent).Status, "failed")
+	})
+	t.Run("Reports approval changes", func(t *testing.T) {
+		current := base()
+		current.approved = true
+		current.approvedBy = []string{"jane"}
+		events := diffSnapshots(base(), current)
+		assert(t, len(events), 1)
+		assert(t, events[0].Type, EventApprovalChanged)
+	})
+	t.Run("Reports updates to the merge request", func(t *testing.T) {
+		current := base()
+		now := time.Now()
+		current.updatedAt = &now
+		events := diffSnapshots(base(), current)
+		assert(t, len(events), 1)
+		assert(t, events[0].Type, EventMergeRequestUpdated)
+	})
+}
+
+/* readEvent returns the name of the next event in a server-sent event stream */
+func readEvent(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Stream ended before an event arrived: %v", err)
+		}
+		if name, found := strings.CutPrefix(line, "event: "); found {
+			return strings.TrimSpace(name)
+		}
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	t.Run("Streams changes and ends when the server shuts down", func(t *testing.T) {
+		client := &fakeMergeRequestWatcher{pipelineStatus: "running"}
+		d := data{state: newSessionState(ProjectInfo{ProjectId: "1", MergeId: 10}, git.GitData{})}
+		broker := newEventBroker(d, client, 5*time.Millisecond)
+
+		s := shutdownService{sigCh: make(chan os.Signal, 1)}
+		s.addCleanup(broker.stop)
+
+		l, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := &http.Server{Handler: LoggingServer{handler: eventsService{d, broker}}}
+		go server.Serve(l) // nolint
+
+		res, err := http.Get("http://" + l.Addr().String() + "/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		assert(t, res.Header.Get("Content-Type"), "text/event-stream")
+		reader := bufio.NewReader(res.Body)
+
+		time.Sleep(20 * time.Millisecond) // Let the first poll establish a baseline
+		client.addNote("abc", 1)
+		assert(t, readEvent(t, reader), string(EventNoteAdded))
+
+		client.mu.Lock()
+		client.pipelineStatus = "success"
+		client.mu.Unlock()
+		assert(t, readEvent(t, reader), string(EventPipelineStatusChanged))
+
+		done := make(chan struct{})
+		go func() {
+			s.WatchForShutdown(server)
+			close(done)
+		}()
+		s.sigCh <- killer{}
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Server did not shut down while a stream was open")
+		}
+	})
+	t.Run("Reads every page of discussions", func(t *testing.T) {
+		client := &fakeMergeRequestWatcher{}
+		for i := range 250 {
+			client.addNote(fmt.Sprintf("discussion-%d", i), int64(i+1))
+		}
+		broker := newEventBroker(data{state: newSessionState(ProjectInfo{ProjectId: "1", MergeId: 10}, git.GitData{})}, client, time.Hour)
+
+		snapshot, err := broker.takeSnapshot(context.Background(), "1", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, len(snapshot.notes), 250)
+	})
+	t.Run("Waits for the branch to get a merge request", func(t *testing.T) {
+		client := &fakeMergeRequestWatcher{pipelineStatus: "running"}
+		d := data{state: newSessionState(ProjectInfo{ProjectId: "1"}, git.GitData{BranchName: "feature"})}
+		broker := newEventBroker(d, client, time.Hour)
+		events := broker.subscribe()
+		defer broker.stop()
+
+		broker.poll()
+		assert(t, d.state.MergeId(), int64(0))
+
+		client.mu.Lock()
+		client.mergeRequests = []*gitlab.BasicMergeRequest{{IID: 10}}
+		client.mu.Unlock()
+		broker.poll()
+		assert(t, d.state.MergeId(), int64(10))
+
+		client.addNote("abc", 1)
+		broker.poll()
+		assert(t, (<-events).Type, EventNoteAdded)
+	})
+	t.Run("Takes a new snapshot when a subscriber connects after nobody was listening", func(t *testing.T) {
+		client := &fakeMergeRequestWatcher{pipelineStatus: "running"}
+		broker := newEventBroker(data{state: newSessionState(ProjectInfo{ProjectId: "1", MergeId: 10}, git.GitData{})}, client, time.Hour)
+		defer broker.stop()
+		waitForSnapshot := func() {
+			t.Helper()
+			deadline := time.Now().Add(2 * time.Second)
+			for time.Now().Before(deadline) {
+				broker.mu.Lock()
+				taken := broker.previous != nil
+				broker.mu.Unlock()
+				if taken {
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+			t.Fatal("Expected a snapshot")
+		}
+
+		events := broker.subscribe()
+		waitForSnapshot()
+		broker.unsubscribe(events)
+
+		client.addNote("abc", 1)
+		events = broker.subscribe()
+		broker.poll()
+		select {
+		case event := <-events:
+			t.Fatalf("Expected no event for a change made while nobody was listening, got %s", event.Type)
+		default:
+		}
+
+		client.addNote("def", 2)
+		broker.poll()
+		assert(t, (<-events).Type, EventNoteAdded)
+	})
+	t.Run("Does not poll without subscribers", func(t *testing.T) {
+		client := &fakeMergeRequestWatcher{testBase: testBase{errFromGitlab: true}}
+		broker := newEventBroker(data{state: newSessionState(ProjectInfo{MergeId: 10}, git.GitData{})}, client, time.Millisecond)
+		broker.poll()
+		if broker.previous != nil {
+			t.Error("Expected no snapshot without subscribers")
+		}
+		broker.stop()
+		_, open := <-broker.subscribe()
+		assert(t, open, false)
+	})
+}
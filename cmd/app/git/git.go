@@ -1,9 +1,17 @@
 package git
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 )
 
@@ -19,6 +27,13 @@ type GitData struct {
 	Namespace   string
 	ProjectName string
 	BranchName  string
+	GitlabUrl   string
+}
+
+/* Instance is a Gitlab instance that git remotes may point to */
+type Instance struct {
+	Url         string   // The URL of the instance, with its relative URL root if it has one
+	RemoteHosts []string // Other hosts that remotes of the instance use, such as SSH aliases
 }
 
 type Git struct{}
@@ -36,7 +51,7 @@ Extracts information about the current repository and returns
 it to the client for initialization. The current directory must be a valid
 Gitlab project and the branch must be a feature branch
 */
-func NewGitData(remote string, gitlabUrl string, g GitManager) (GitData, error) {
+func NewGitData(remote string, instances []Instance, g GitManager) (GitData, error) {
 	err := g.RefreshProjectInfo(remote)
 	if err != nil {
 		return GitData{}, fmt.Errorf("could not get latest information from remote: %v", err)
@@ -47,6 +62,12 @@ func NewGitData(remote string, gitlabUrl string, g GitManager) (GitData, error)
 		return GitData{}, fmt.Errorf("could not get project Url: %v", err)
 	}
 
+	instance, err := pickInstance(url, instances)
+	if err != nil {
+		return GitData{}, err
+	}
+	gitlabUrl := instance.Url
+
 	/*
 	   This should match following formats:
 	       namespace: namespace, projectName: dummy-test-repo:
@@ -85,10 +106,75 @@ func NewGitData(remote string, gitlabUrl string, g GitManager) (GitData, error)
 			Namespace:   namespace,
 			ProjectName: projectName,
 			BranchName:  branchName,
+			GitlabUrl:   gitlabUrl,
 		},
 		nil
 }
 
+/* remoteHost returns the host of a remote URL in any of the formats that NewGitData accepts */
+func remoteHost(remoteUrl string) string {
+	if strings.Contains(remoteUrl, "://") {
+		u, err := url.Parse(remoteUrl)
+		if err != nil {
+			return ""
+		}
+		return u.Hostname()
+	}
+
+	/* The scp-like syntax of SSH, as in git@gitlab.com:namespace/project.git */
+	host, _, _ := strings.Cut(remoteUrl, ":")
+	return host[strings.LastIndex(host, "@")+1:]
+}
+
+/*
+pickInstance finds the Gitlab instance of a remote by its host. When several instances share a host under
+different relative URL roots, the one whose root starts the path of the remote wins, and SSH remotes, whose
+paths never carry the root, go to the first of them. A single instance is used for any remote, as it was before
+instances could be configured, since its remote may be an SSH alias or a separate SSH host.
+*/
+func pickInstance(remoteUrl string, instances []Instance) (Instance, error) {
+	switch len(instances) {
+	case 0:
+		return Instance{}, errors.New("no Gitlab instance is configured")
+	case 1:
+		return instances[0], nil
+	}
+
+	host := remoteHost(remoteUrl)
+	best, bestRank := -1, -1
+	for i, instance := range instances {
+		u, err := url.Parse(instance.Url)
+		if err != nil {
+			continue
+		}
+		hosts := append([]string{u.Hostname()}, instance.RemoteHosts...)
+		if !slices.ContainsFunc(hosts, func(h string) bool { return strings.EqualFold(h, host) }) {
+			continue
+		}
+
+		/* An instance whose root is in the path beats one without a root, which beats one whose root is not in it */
+		rank := 1
+		if root := strings.Trim(u.Path, "/"); root != "" {
+			rank = 0
+			if strings.Contains(remoteUrl, "/"+root+"/") {
+				rank = 1 + len(root)
+			}
+		}
+		if rank > bestRank {
+			best, bestRank = i, rank
+		}
+	}
+
+	if best == -1 {
+		urls := []string{}
+		for _, instance := range instances {
+			urls = append(urls, instance.Url)
+		}
+		return Instance{}, fmt.Errorf("no Gitlab instance matches the host %q of the remote %s, the configured instances are %s", host, remoteUrl, strings.Join(urls, ", "))
+	}
+	return instances[best], nil
+}
+
 /* Gets the current branch name */
 func (g Git) GetCurrentBranchNameFromNativeGitCmd() (res string, e error) {
 	gitCmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
@@ -103,6 +189,17 @@ func (g Git) GetCurrentBranchNameFromNativeGitCmd() (res string, e error) {
 	return branchName, nil
 }
 
+/* Gets the absolute path of the HEAD file, which lives outside of .git in worktrees */
+func (g Git) GetHeadPathFromNativeGitCmd() (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--git-path", "HEAD")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error running git rev-parse: %w", err)
+	}
+
+	return filepath.Abs(strings.TrimSpace(string(output)))
+}
+
 /* Gets the project SSH or HTTPS url */
 func (g Git) GetProjectUrlFromNativeGitCmd(remote string) (string, error) {
 	cmd := exec.Command("git", "remote", "get-url", remote)
@@ -136,3 +233,35 @@ func (g Git) GetLatestCommitOnRemote(remote string, branchName string) (string,
 	commit := strings.TrimSpace(string(out))
 	return commit, nil
 }
+
+/*
+Asks the configured git credential helpers for the password of a Gitlab instance, as `git credential fill` does.
+Git is told not to prompt, so that a missing credential fails instead of waiting for input nobody can give.
+*/
+func (g Git) CredentialFill(ctx context.Context, gitlabUrl string) (string, error) {
+	u, err := url.Parse(gitlabUrl)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid Gitlab url %q", gitlabUrl)
+	}
+
+	input := fmt.Sprintf("protocol=%s\nhost=%s\n", u.Scheme, u.Host)
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		input += fmt.Sprintf("path=%s\n", path)
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(input + "\n")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run `git credential fill`: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if password, ok := strings.CutPrefix(scanner.Text(), "password="); ok {
+			return password, nil
+		}
+	}
+	return "", fmt.Errorf("no credential for %s", u.Host)
+}
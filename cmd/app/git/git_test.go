@@ -1,7 +1,9 @@
 package git
 
 import (
+	"context"
 	"errors"
+	"os"
 	"testing"
 )
 
@@ -200,7 +202,7 @@ func TestExtractGitInfo_Success(t *testing.T) {
 				BranchName:  tC.branch,
 				RemoteUrl:   tC.remote,
 			}
-			data, err := NewGitData(tC.remote, tC.url, g)
+			data, err := NewGitData(tC.remote, []Instance{{Url: tC.url}}, g)
 			if err != nil {
 				t.Errorf("No error was expected, got %s", err)
 			}
@@ -245,7 +247,7 @@ func TestExtractGitInfo_FailToGetProjectRemoteUrl(t *testing.T) {
 		g := failingUrlManager{
 			errMsg: tC.errMsg,
 		}
-		_, err := NewGitData("", "", g)
+		_, err := NewGitData("", []Instance{{}}, g)
 		if err == nil {
 			t.Errorf("Expected an error, got none")
 		}
@@ -277,7 +279,7 @@ func TestExtractGitInfo_FailToGetCurrentBranchName(t *testing.T) {
 			},
 			errMsg: tC.errMsg,
 		}
-		_, err := NewGitData("", "", g)
+		_, err := NewGitData("", []Instance{{}}, g)
 		if err == nil {
 			t.Errorf("Expected an error, got none")
 		}
@@ -286,3 +288,106 @@ func TestExtractGitInfo_FailToGetCurrentBranchName(t *testing.T) {
 		}
 	})
 }
+
+func TestExtractGitInfo_PicksInstanceByRemoteHost(t *testing.T) {
+	instances := []Instance{
+		{Url: "https://gitlab.com"},
+		{Url: "https://git.example.com", RemoteHosts: []string{"example-ssh"}},
+		{Url: "https://tools.example.org/gitlab"},
+		{Url: "https://tools.example.org/gitlab-archive"},
+	}
+	testCases := []struct {
+		desc      string
+		remote    string
+		gitlabUrl string
+		namespace string
+	}{
+		{"Matches the host of an HTTPS remote", "https://git.example.com/team/project-name.git", "https://git.example.com", "team"},
+		{"Matches the host of an SSH remote", "git@gitlab.com:team/project-name.git", "https://gitlab.com", "team"},
+		{"Matches the host regardless of case and port", "ssh://git@GIT.example.com:2222/team/project-name.git", "https://git.example.com", "team"},
+		{"Matches an SSH alias of the instance", "git@example-ssh:team/project-name.git", "https://git.example.com", "team"},
+		{"Tells instances on one host apart by their relative URL root", "https://tools.example.org/gitlab-archive/team/project-name.git", "https://tools.example.org/gitlab-archive", "team"},
+		{"Matches an SSH remote, which has no relative URL root, to an instance with one", "git@tools.example.org:team/project-name.git", "https://tools.example.org/gitlab", "team"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			g := FakeGitManager{RemoteUrl: tC.remote, BranchName: "feature/abc"}
+			data, err := NewGitData("origin", instances, g)
+			if err != nil {
+				t.Fatalf("No error was expected, got %s", err)
+			}
+			if data.GitlabUrl != tC.gitlabUrl {
+				t.Errorf("\nExpected Gitlab URL: %s\nActual:   %s", tC.gitlabUrl, data.GitlabUrl)
+			}
+			if data.Namespace != tC.namespace {
+				t.Errorf("\nExpected Namespace: %s\nActual:   %s", tC.namespace, data.Namespace)
+			}
+		})
+	}
+
+	t.Run("Reports when no instance matches the host", func(t *testing.T) {
+		g := FakeGitManager{RemoteUrl: "git@github.com:team/project-name.git", BranchName: "feature/abc"}
+		_, err := NewGitData("origin", instances, g)
+		expectedErr := `no Gitlab instance matches the host "github.com" of the remote git@github.com:team/project-name.git, the configured instances are https://gitlab.com, https://git.example.com, https://tools.example.org/gitlab, https://tools.example.org/gitlab-archive`
+		if err == nil || err.Error() != expectedErr {
+			t.Errorf("\nExpected: %s\nActual:   %v", expectedErr, err)
+		}
+	})
+	t.Run("Matches an SSH alias of an instance with a relative URL root next to the top-level instance", func(t *testing.T) {
+		instances := []Instance{
+			{Url: "https://git.example.com/gitlab", RemoteHosts: []string{"git-ssh.example.com"}},
+			{Url: "https://gitlab.com"},
+		}
+		g := FakeGitManager{RemoteUrl: "git@git-ssh.example.com:team/project-name.git", BranchName: "feature/abc"}
+		data, err := NewGitData("origin", instances, g)
+		if err != nil {
+			t.Fatalf("No error was expected, got %s", err)
+		}
+		if data.GitlabUrl != "https://git.example.com/gitlab" {
+			t.Errorf("\nExpected Gitlab URL: https://git.example.com/gitlab\nActual:   %s", data.GitlabUrl)
+		}
+	})
+	t.Run("Uses the only instance for a remote on an SSH alias", func(t *testing.T) {
+		for _, tC := range []struct{ remote, gitlabUrl string }{
+			{"git@gitlab-work:namespace-1/project-name.git", "https://gitlab.com"},
+			{"git@ssh.gitlab.corp.com:namespace-1/project-name.git", "https://gitlab.corp.com"},
+		} {
+			g := FakeGitManager{RemoteUrl: tC.remote, BranchName: "feature/abc"}
+			data, err := NewGitData("origin", []Instance{{Url: tC.gitlabUrl}}, g)
+			if err != nil {
+				t.Fatalf("No error was expected, got %s", err)
+			}
+			if data.GitlabUrl != tC.gitlabUrl {
+				t.Errorf("\nExpected Gitlab URL: %s\nActual:   %s", tC.gitlabUrl, data.GitlabUrl)
+			}
+		}
+	})
+}
+
+func useCredentialHelper(t *testing.T, helper string) {
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "credential.helper")
+	t.Setenv("GIT_CONFIG_VALUE_0", helper)
+}
+
+func TestCredentialFill(t *testing.T) {
+	t.Run("Returns the password the helper knows for the host", func(t *testing.T) {
+		useCredentialHelper(t, `!f() { test "$1" = get && grep -q host=gitlab.example.com && printf "username=oauth2\npassword=secret-token\n"; }; f`)
+		token, err := Git{}.CredentialFill(context.Background(), "https://gitlab.example.com")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if token != "secret-token" {
+			t.Errorf("Expected secret-token, got %q", token)
+		}
+	})
+	t.Run("Fails instead of prompting when no helper knows the host", func(t *testing.T) {
+		useCredentialHelper(t, "")
+		_, err := Git{}.CredentialFill(context.Background(), "https://gitlab.example.com")
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+}
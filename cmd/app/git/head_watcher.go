@@ -0,0 +1,145 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type HeadLocator interface {
+	GetHeadPathFromNativeGitCmd() (string, error)
+	GetCurrentBranchNameFromNativeGitCmd() (string, error)
+}
+
+/* BranchChange records a checkout that moved HEAD from one branch to another */
+type BranchChange struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	At   time.Time `json:"at"`
+}
+
+/*
+HeadWatcher notices when HEAD moves to another branch, for instance after a `git checkout` in a terminal.
+Reading the HEAD file is cheap, so Check can run before every request. The branch name is only resolved
+through git when the file changes. Watch additionally checks in the background.
+*/
+type HeadWatcher struct {
+	locator  HeadLocator
+	headPath string
+
+	checkMu    sync.Mutex
+	mu         sync.Mutex
+	head       string
+	branch     string
+	lastChange *BranchChange
+	listeners  []func(BranchChange)
+	done       chan struct{}
+	stopOnce   sync.Once
+}
+
+func NewHeadWatcher(locator HeadLocator) (*HeadWatcher, error) {
+	headPath, err := locator.GetHeadPathFromNativeGitCmd()
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := os.ReadFile(headPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read HEAD: %w", err)
+	}
+
+	branch, err := locator.GetCurrentBranchNameFromNativeGitCmd()
+	if err != nil {
+		return nil, err
+	}
+
+	return &HeadWatcher{
+		locator:  locator,
+		headPath: headPath,
+		head:     string(head),
+		branch:   branch,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+/* OnChange registers a function that is called with every branch change, before Check returns */
+func (w *HeadWatcher) OnChange(f func(BranchChange)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.listeners = append(w.listeners, f)
+}
+
+/* Check compares HEAD with the last known value and notifies the listeners if the branch changed */
+func (w *HeadWatcher) Check() error {
+	w.checkMu.Lock()
+	defer w.checkMu.Unlock()
+
+	head, err := os.ReadFile(w.headPath)
+	if err != nil {
+		return fmt.Errorf("could not read HEAD: %w", err)
+	}
+
+	w.mu.Lock()
+	unchanged := string(head) == w.head
+	w.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	branch, err := w.locator.GetCurrentBranchNameFromNativeGitCmd()
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.head = string(head)
+	if branch == w.branch {
+		w.mu.Unlock()
+		return nil
+	}
+	change := BranchChange{From: w.branch, To: branch, At: time.Now()}
+	w.branch = branch
+	w.lastChange = &change
+	listeners := append([]func(BranchChange){}, w.listeners...)
+	w.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(change)
+	}
+
+	return nil
+}
+
+/* Watch checks HEAD at the given interval until Stop is called */
+func (w *HeadWatcher) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				_ = w.Check() // A failed check is retried on the next tick or request
+			}
+		}
+	}()
+}
+
+func (w *HeadWatcher) Stop() {
+	w.stopOnce.Do(func() { close(w.done) })
+}
+
+func (w *HeadWatcher) Branch() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.branch
+}
+
+/* LastChange returns the most recent branch change, or nil if HEAD has not moved since startup */
+func (w *HeadWatcher) LastChange() *BranchChange {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastChange
+}
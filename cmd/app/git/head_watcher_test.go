@@ -0,0 +1,97 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/* fakeHeadLocator reads the branch name out of a HEAD file that the test writes to */
+type fakeHeadLocator struct {
+	headPath string
+	calls    *int
+}
+
+func (f fakeHeadLocator) GetHeadPathFromNativeGitCmd() (string, error) {
+	return f.headPath, nil
+}
+
+func (f fakeHeadLocator) GetCurrentBranchNameFromNativeGitCmd() (string, error) {
+	*f.calls++
+	head, err := os.ReadFile(f.headPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(string(head)), nil
+}
+
+func newTestHeadWatcher(t *testing.T, branch string) (*HeadWatcher, fakeHeadLocator) {
+	t.Helper()
+	locator := fakeHeadLocator{headPath: filepath.Join(t.TempDir(), "HEAD"), calls: new(int)}
+	checkout(t, locator, branch)
+	watcher, err := NewHeadWatcher(locator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return watcher, locator
+}
+
+func checkout(t *testing.T, locator fakeHeadLocator, branch string) {
+	t.Helper()
+	err := os.WriteFile(locator.headPath, []byte("ref: refs/heads/"+branch), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHeadWatcher(t *testing.T) {
+	t.Run("Notifies listeners when the branch changes", func(t *testing.T) {
+		watcher, locator := newTestHeadWatcher(t, "main")
+		var changes []BranchChange
+		watcher.OnChange(func(c BranchChange) { changes = append(changes, c) })
+
+		checkout(t, locator, "feature")
+		if err := watcher.Check(); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(changes) != 1 || changes[0].From != "main" || changes[0].To != "feature" {
+			t.Fatalf("Expected one change from main to feature, got %v", changes)
+		}
+		if watcher.Branch() != "feature" {
+			t.Errorf("Expected branch feature, got %s", watcher.Branch())
+		}
+		if last := watcher.LastChange(); last == nil || last.To != "feature" {
+			t.Errorf("Expected the last change to be recorded, got %v", last)
+		}
+	})
+	t.Run("Only asks git for the branch when HEAD changes", func(t *testing.T) {
+		watcher, locator := newTestHeadWatcher(t, "main")
+		*locator.calls = 0
+
+		for i := 0; i < 3; i++ {
+			if err := watcher.Check(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if *locator.calls != 0 {
+			t.Errorf("Expected no git calls, got %d", *locator.calls)
+		}
+		if watcher.LastChange() != nil {
+			t.Errorf("Expected no change, got %v", watcher.LastChange())
+		}
+	})
+	t.Run("Returns an error when HEAD cannot be read", func(t *testing.T) {
+		watcher, locator := newTestHeadWatcher(t, "main")
+		if err := os.Remove(locator.headPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := watcher.Check(); err == nil {
+			t.Error("Expected an error")
+		}
+		if watcher.Branch() != "main" {
+			t.Errorf("Expected the last known branch to be kept, got %s", watcher.Branch())
+		}
+	})
+}
@@ -21,9 +21,13 @@ type infoService struct {
 	client MergeRequestGetter
 }
 
+func (a infoService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodGet: InfoResponse{}}
+}
+
 /* infoHandler fetches infomation about the current git project. The data returned here is used in many other API calls */
 func (a infoService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	mr, res, err := a.client.GetMergeRequest(a.projectInfo.ProjectId, a.projectInfo.MergeId, &gitlab.GetMergeRequestsOptions{})
+	mr, res, err := a.client.GetMergeRequest(a.projectId(r), a.mergeId(r), &gitlab.GetMergeRequestsOptions{}, gitlab.WithContext(r.Context()))
 	if err != nil {
 		handleError(w, err, "Could not get project info", http.StatusInternalServerError)
 		return
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
+)
+
+/*
+instances lists the configured Gitlab instances. The top-level settings describe one more instance, which comes
+last, so that a project's own `.gitlab.nvim` file keeps working next to the shared instances.
+*/
+func (p PluginOptions) instances() []InstanceOptions {
+	instances := []InstanceOptions{}
+	for _, instance := range p.Instances {
+		instance.GitlabUrl = strings.TrimSuffix(instance.GitlabUrl, "/")
+		instances = append(instances, instance)
+	}
+	if p.GitlabUrl != "" || len(instances) == 0 {
+		instances = append(instances, InstanceOptions{
+			GitlabUrl:          strings.TrimSuffix(p.GitlabUrl, "/"),
+			AuthToken:          p.AuthToken,
+			Auth:               p.Auth,
+			ConnectionSettings: p.ConnectionSettings.TransportSettings,
+		})
+	}
+	return instances
+}
+
+/* GitInstances lists the Gitlab instances that the git remote is matched against */
+func GitInstances() []git.Instance {
+	instances := []git.Instance{}
+	for _, instance := range pluginOptions.instances() {
+		instances = append(instances, git.Instance{Url: instance.GitlabUrl, RemoteHosts: instance.RemoteHosts})
+	}
+	return instances
+}
+
+/* UseInstance makes the Gitlab instance with the given URL, as picked for the git remote, the one the server talks to */
+func UseInstance(gitlabUrl string) error {
+	for _, instance := range pluginOptions.instances() {
+		if instance.GitlabUrl != gitlabUrl {
+			continue
+		}
+		pluginOptions.GitlabUrl = instance.GitlabUrl
+		pluginOptions.AuthToken = instance.AuthToken
+		pluginOptions.Auth = instance.Auth
+		pluginOptions.ConnectionSettings.TransportSettings = instance.ConnectionSettings
+		return nil
+	}
+	return fmt.Errorf("no Gitlab instance is configured with the URL %s", gitlabUrl)
+}
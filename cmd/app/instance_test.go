@@ -0,0 +1,57 @@
+package app
+
+import (
+	"testing"
+)
+
+func TestUseInstance(t *testing.T) {
+	originalOptions := pluginOptions
+	defer func() { pluginOptions = originalOptions }()
+
+	useTestInstances := func() {
+		pluginOptions = PluginOptions{GitlabUrl: "https://gitlab.com", AuthToken: "project-token"}
+		pluginOptions.ConnectionSettings.Proxy = "http://proxy.example.com:3128"
+		pluginOptions.ConnectionSettings.Remote = "origin"
+		instance := InstanceOptions{GitlabUrl: "https://git.example.com/gitlab/", RemoteHosts: []string{"example-ssh"}}
+		instance.Auth.TokenEnv = "EXAMPLE_TOKEN"
+		instance.ConnectionSettings.Insecure = true
+		pluginOptions.Instances = []InstanceOptions{instance}
+	}
+
+	t.Run("Lists the configured instances before the one of the top-level settings", func(t *testing.T) {
+		useTestInstances()
+		instances := GitInstances()
+		assert(t, len(instances), 2)
+		assert(t, instances[0].Url, "https://git.example.com/gitlab")
+		assert(t, instances[0].RemoteHosts[0], "example-ssh")
+		assert(t, instances[1].Url, "https://gitlab.com")
+	})
+	t.Run("Uses the URL, token and connection settings of the picked instance", func(t *testing.T) {
+		useTestInstances()
+		if err := UseInstance("https://git.example.com/gitlab"); err != nil {
+			t.Fatal(err)
+		}
+		assert(t, pluginOptions.GitlabUrl, "https://git.example.com/gitlab")
+		assert(t, pluginOptions.AuthToken, "")
+		assert(t, pluginOptions.Auth.TokenEnv, "EXAMPLE_TOKEN")
+		assert(t, pluginOptions.ConnectionSettings.Proxy, "")
+		assert(t, pluginOptions.ConnectionSettings.Insecure, true)
+		assert(t, pluginOptions.ConnectionSettings.Remote, "origin")
+	})
+	t.Run("Keeps the top-level settings for their own instance", func(t *testing.T) {
+		useTestInstances()
+		if err := UseInstance("https://gitlab.com"); err != nil {
+			t.Fatal(err)
+		}
+		assert(t, pluginOptions.AuthToken, "project-token")
+		assert(t, pluginOptions.ConnectionSettings.Proxy, "http://proxy.example.com:3128")
+	})
+	t.Run("Fails for an unknown instance", func(t *testing.T) {
+		useTestInstances()
+		err := UseInstance("https://unknown.example.com")
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		assert(t, err.Error(), "no Gitlab instance is configured with the URL https://unknown.example.com")
+	})
+}
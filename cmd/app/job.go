@@ -27,12 +27,16 @@ type traceFileService struct {
 	client TraceFileGetter
 }
 
+func (a traceFileService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodGet: JobTraceResponse{}}
+}
+
 /* jobHandler returns a string that shows the output of a specific job run in a Gitlab pipeline */
 func (a traceFileService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	payload := r.Context().Value(payload("payload")).(*JobTraceRequest)
 
-	reader, res, err := a.client.GetTraceFile(a.projectInfo.ProjectId, payload.JobId)
+	reader, res, err := a.client.GetTraceFile(a.projectId(r), payload.JobId, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not get trace file for job", http.StatusInternalServerError)
@@ -37,6 +37,10 @@ type labelService struct {
 	client LabelManager
 }
 
+func (a labelService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodGet: LabelsRequestResponse{}, http.MethodPut: LabelUpdateResponse{}}
+}
+
 /* labelsHandler adds or removes labels from a merge request, and returns all labels for the current project */
 func (a labelService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -50,7 +54,7 @@ func (a labelService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (a labelService) getLabels(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	labels, res, err := a.client.ListLabels(a.projectInfo.ProjectId, &gitlab.ListLabelsOptions{})
+	labels, res, err := a.client.ListLabels(a.projectId(r), &gitlab.ListLabelsOptions{}, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not modify merge request labels", http.StatusInternalServerError)
@@ -102,9 +106,9 @@ func (a labelService) updateLabels(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var labels = gitlab.LabelOptions(labelUpdateRequest.Labels)
-	mr, res, err := a.client.UpdateMergeRequest(a.projectInfo.ProjectId, a.projectInfo.MergeId, &gitlab.UpdateMergeRequestOptions{
+	mr, res, err := a.client.UpdateMergeRequest(a.projectId(r), a.mergeId(r), &gitlab.UpdateMergeRequestOptions{
 		Labels: &labels,
-	})
+	}, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not modify merge request labels", http.StatusInternalServerError)
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"net/http"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+/* Used when the connection settings leave the limits out */
+const (
+	defaultMaxInFlight       = 10
+	defaultRequestsPerSecond = 20
+)
+
+/*
+outboundLimiter bounds the calls to GitLab across the whole server: at most maxInFlight calls run at once, and
+new calls start at a steady rate, with bursts of up to maxInFlight calls.
+*/
+type outboundLimiter struct {
+	maxInFlight int
+	slots       chan struct{}
+	rate        *rate.Limiter
+}
+
+func newOutboundLimiter(maxInFlight int, requestsPerSecond float64) *outboundLimiter {
+	if maxInFlight <= 0 {
+		maxInFlight = defaultMaxInFlight
+	}
+	limit := rate.Limit(requestsPerSecond)
+	if requestsPerSecond <= 0 {
+		limit = defaultRequestsPerSecond
+	}
+	return &outboundLimiter{
+		maxInFlight: maxInFlight,
+		slots:       make(chan struct{}, maxInFlight),
+		rate:        rate.NewLimiter(limit, maxInFlight),
+	}
+}
+
+var outbound = newOutboundLimiter(defaultMaxInFlight, defaultRequestsPerSecond)
+
+/* setupOutboundLimiter configures the limiter from the connection settings */
+func setupOutboundLimiter() {
+	settings := pluginOptions.ConnectionSettings
+	outbound = newOutboundLimiter(settings.MaxInFlight, settings.RequestsPerSecond)
+}
+
+/* limitTransport makes every call to GitLab wait for the outbound limiter */
+type limitTransport struct {
+	next    http.RoundTripper
+	limiter *outboundLimiter
+}
+
+func (t limitTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	select {
+	case t.limiter.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-t.limiter.slots }()
+
+	if err := t.limiter.rate.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(r)
+}
+
+/*
+fanOut calls fn for every item in parallel and returns the results in the order of the items, or the first error.
+It starts no more goroutines than the outbound limiter lets calls run at once, since any more would only wait.
+*/
+func fanOut[T any, R any](items []T, fn func(T) (R, error)) ([]R, error) {
+	results := make([]R, len(items))
+	errs := make([]error, len(items))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(outbound.maxInFlight, len(items)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i], errs[i] = fn(items[i])
+			}
+		}()
+	}
+	for i := range items {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/* concurrencyRecorder is a transport that tracks how many calls run at once */
+type concurrencyRecorder struct {
+	running atomic.Int32
+	peak    atomic.Int32
+}
+
+func (c *concurrencyRecorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	running := c.running.Add(1)
+	defer c.running.Add(-1)
+	for {
+		peak := c.peak.Load()
+		if running <= peak || c.peak.CompareAndSwap(peak, running) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return fakeRoundTripper{status: http.StatusOK}.RoundTrip(r)
+}
+
+func TestLimitTransport(t *testing.T) {
+	t.Run("Bounds the calls that run at once", func(t *testing.T) {
+		recorder := &concurrencyRecorder{}
+		client := &http.Client{Transport: limitTransport{recorder, newOutboundLimiter(3, 1000)}}
+
+		var wg sync.WaitGroup
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res, err := client.Get("https://gitlab.com/api/v4/user")
+				if err == nil {
+					res.Body.Close()
+				}
+			}()
+		}
+		wg.Wait()
+		assert(t, recorder.peak.Load(), int32(3))
+	})
+	t.Run("Starts calls at the configured rate once the burst is used up", func(t *testing.T) {
+		client := &http.Client{Transport: limitTransport{fakeRoundTripper{status: http.StatusOK}, newOutboundLimiter(2, 50)}}
+
+		start := time.Now()
+		for range 4 {
+			res, err := client.Get("https://gitlab.com/api/v4/user")
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+		}
+		if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+			t.Errorf("Expected the calls after the burst to wait, took %s", elapsed)
+		}
+	})
+}
+
+func TestFanOut(t *testing.T) {
+	original := outbound
+	outbound = newOutboundLimiter(2, 1000)
+	t.Cleanup(func() { outbound = original })
+
+	t.Run("Returns the results in order with bounded parallelism", func(t *testing.T) {
+		var running, peak atomic.Int32
+		results, err := fanOut([]int{1, 2, 3, 4, 5}, func(i int) (int, error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			if n > peak.Load() {
+				peak.Store(n)
+			}
+			time.Sleep(time.Millisecond)
+			return i * 10, nil
+		})
+		assert(t, err, nil)
+		assert(t, len(results), 5)
+		for i, result := range results {
+			assert(t, result, (i+1)*10)
+		}
+		if peak.Load() > 2 {
+			t.Errorf("Expected at most 2 calls at once, got %d", peak.Load())
+		}
+	})
+	t.Run("Returns the first error", func(t *testing.T) {
+		_, err := fanOut([]int{1, 2, 3}, func(i int) (int, error) {
+			if i >= 2 {
+				return 0, errors.New("failed")
+			}
+			return i, nil
+		})
+		if err == nil || err.Error() != "failed" {
+			t.Errorf("Expected the error of the failed call, got %v", err)
+		}
+	})
+}
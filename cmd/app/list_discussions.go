@@ -1,9 +1,9 @@
 package app
 
 import (
+	"context"
 	"net/http"
 	"sort"
-	"sync"
 	"time"
 
 	"encoding/json"
@@ -75,6 +75,10 @@ type discussionsListerService struct {
 	client DiscussionsLister
 }
 
+func (a discussionsListerService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPost: DiscussionsResponse{}}
+}
+
 /*
 listDiscussionsHandler lists all discusions for a given merge request, both those linked and unlinked to particular points in the code.
 The responses are sorted by date created, and blacklisted users are not included
@@ -90,7 +94,7 @@ func (a discussionsListerService) ServeHTTP(w http.ResponseWriter, r *http.Reque
 		},
 	}
 
-	discussions, res, err := a.client.ListMergeRequestDiscussions(a.projectInfo.ProjectId, a.projectInfo.MergeId, &mergeRequestDiscussionOptions)
+	discussions, res, err := a.client.ListMergeRequestDiscussions(a.projectId(r), a.mergeId(r), &mergeRequestDiscussionOptions, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not list discussions", http.StatusInternalServerError)
@@ -130,7 +134,7 @@ func (a discussionsListerService) ServeHTTP(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
-	emojis, err := a.fetchEmojisForNotesAndComments(noteIds)
+	emojis, err := a.fetchEmojisForNotesAndComments(r.Context(), a.projectId(r), a.mergeId(r), noteIds)
 	if err != nil {
 		handleError(w, err, "Could not fetch emojis", http.StatusInternalServerError)
 		return
@@ -166,55 +170,20 @@ func (a discussionsListerService) ServeHTTP(w http.ResponseWriter, r *http.Reque
 Fetches emojis for a set of notes and comments in parallel and returns a map of note IDs to their emojis.
 Gitlab's API does not allow for fetching notes for an entire discussion thread so we have to do it per-note.
 */
-func (a discussionsListerService) fetchEmojisForNotesAndComments(noteIDs []int64) (map[int64][]*gitlab.AwardEmoji, error) {
-	var wg sync.WaitGroup
-
-	emojis := make(map[int64][]*gitlab.AwardEmoji)
-	mu := &sync.Mutex{}
-	errs := make(chan error, len(noteIDs))
-	emojiChan := make(chan struct {
-		noteID int64
-		emojis []*gitlab.AwardEmoji
-	}, len(noteIDs))
-
-	for _, noteID := range noteIDs {
-		wg.Add(1)
-		go func(noteID int64) {
-			defer wg.Done()
-			emojis, _, err := a.client.ListMergeRequestAwardEmojiOnNote(a.projectInfo.ProjectId, a.projectInfo.MergeId, noteID, &gitlab.ListAwardEmojiOptions{})
-			if err != nil {
-				errs <- err
-				return
-			}
-			emojiChan <- struct {
-				noteID int64
-				emojis []*gitlab.AwardEmoji
-			}{noteID, emojis}
-		}(noteID)
-	}
+func (a discussionsListerService) fetchEmojisForNotesAndComments(ctx context.Context, projectId string, mergeId int64, noteIDs []int64) (_ map[int64][]*gitlab.AwardEmoji, err error) {
+	defer metrics.time("emoji_fanout")(&err)
 
-	/* Close the channels when all goroutines finish */
-	go func() {
-		wg.Wait()
-		close(errs)
-		close(emojiChan)
-	}()
-
-	/* Collect emojis */
-	for e := range emojiChan {
-		mu.Lock()
-		emojis[e.noteID] = e.emojis
-		mu.Unlock()
+	results, err := fanOut(noteIDs, func(noteID int64) ([]*gitlab.AwardEmoji, error) {
+		emojis, _, err := a.client.ListMergeRequestAwardEmojiOnNote(projectId, mergeId, noteID, &gitlab.ListAwardEmojiOptions{}, gitlab.WithContext(ctx))
+		return emojis, err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	/* Check if any errors occurred */
-	if len(errs) > 0 {
-		for err := range errs {
-			if err != nil {
-				return nil, err
-			}
-		}
+	emojis := make(map[int64][]*gitlab.AwardEmoji, len(noteIDs))
+	for i, noteID := range noteIDs {
+		emojis[noteID] = results[i]
 	}
-
 	return emojis, nil
 }
@@ -2,14 +2,167 @@ package app
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
+/* The log file is rotated once it grows past this size, keeping this many old files next to it */
+const (
+	maxLogSize    = 10 * 1024 * 1024
+	maxLogBackups = 3
+)
+
+/* The ID of the request to the Go server that a log record belongs to */
+const requestIdKey = contextKey("request_id")
+
+/*
+logger writes JSON records to the log file. Every request and every call to GitLab is logged at the info
+level. The debug settings lower the level to debug and add the bodies of the requests and responses they name.
+*/
+var logger = slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+/* setupLogger configures the logger from the plugin options */
+func setupLogger() {
+	level := slog.LevelInfo
+	if d := pluginOptions.Debug; d.Request || d.Response || d.GitlabRequest || d.GitlabResponse {
+		level = slog.LevelDebug
+	}
+
+	var w io.Writer = io.Discard
+	if pluginOptions.LogPath != "" {
+		w = &rotatingFile{path: pluginOptions.LogPath, maxSize: maxLogSize, backups: maxLogBackups}
+	}
+
+	logger = slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+/*
+rotatingFile appends to the log file and rotates it once it grows past maxSize, keeping old files as path.1,
+path.2 and so on. Logging must never take the server down, so a failure is reported on stderr once and the
+record is dropped.
+*/
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+	failed  bool
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			f.fail(err)
+			return len(p), nil
+		}
+	}
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			f.fail(err)
+			return len(p), nil
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err != nil {
+		f.fail(err)
+	}
+	return len(p), nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close() // nolint
+		return err
+	}
+	/* The log can hold request bodies, so a file left readable by an older version is tightened */
+	if info.Mode().Perm()&0077 != 0 {
+		if err := file.Chmod(0600); err != nil {
+			file.Close() // nolint
+			return err
+		}
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) rotate() error {
+	f.file.Close() // nolint
+	f.file = nil
+
+	for i := f.backups - 1; i > 0; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1)) // Missing backups are fine
+	}
+	if f.backups > 0 {
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+
+	return f.open()
+}
+
+func (f *rotatingFile) fail(err error) {
+	if !f.failed {
+		fmt.Fprintf(os.Stderr, "Could not write to log file %s: %s\n", f.path, err)
+		f.failed = true
+	}
+}
+
+/* requestId returns the ID of the request to the Go server that a context belongs to, if any */
+func requestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+func newRequestId() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+/* Headers carrying the GitLab token or the server secret, which must never be written to the log */
+var sensitiveHeaders = []string{"Private-Token", "Authorization", "Cookie"}
+
+/* redactHeaders copies headers for the log, replacing the sensitive ones */
+func redactHeaders(h http.Header) map[string]string {
+	headers := map[string]string{}
+	for name, values := range h {
+		if Contains(sensitiveHeaders, http.CanonicalHeaderKey(name)) {
+			headers[name] = "REDACTED"
+			continue
+		}
+		headers[name] = strings.Join(values, ", ")
+	}
+	return headers
+}
+
 // LoggingServer is a wrapper around an http.Handler to log incoming requests and outgoing responses.
 type LoggingServer struct {
 	handler http.Handler
@@ -18,79 +171,149 @@ type LoggingServer struct {
 type LoggingResponseWriter struct {
 	statusCode int
 	body       *bytes.Buffer
+	cache      *cacheUsage
 	http.ResponseWriter
 }
 
+/* markCache flags responses that rely on answers from the disk cache, before their headers are sent */
+func (l *LoggingResponseWriter) markCache() {
+	if l.statusCode == 0 && l.cache.servedStale() {
+		l.Header().Set(cacheHeader, "stale")
+	}
+}
+
 func (l *LoggingResponseWriter) WriteHeader(statusCode int) {
-	l.statusCode = statusCode
+	l.markCache()
+	if l.statusCode == 0 {
+		l.statusCode = statusCode
+	}
+	l.ResponseWriter.WriteHeader(statusCode)
 }
 
+/* Write keeps a copy of the body when it is logged, which is always the case for errors */
 func (l *LoggingResponseWriter) Write(b []byte) (int, error) {
-	l.body.Write(b)
+	l.markCache()
+	if l.statusCode == 0 {
+		l.statusCode = http.StatusOK
+	}
+	if pluginOptions.Debug.Response || l.statusCode >= 400 {
+		l.body.Write(b)
+	}
 	return l.ResponseWriter.Write(b)
 }
 
-// Logs the request, calls the original handler on the ServeMux, then logs the response
+/* Flush lets streaming handlers such as the /events endpoint push data through the logging wrapper */
+func (l *LoggingResponseWriter) Flush() {
+	if f, ok := l.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Tags the request with an ID, calls the original handler on the ServeMux, then logs the outcome
 func (l LoggingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	w.Header().Set("Content-Type", "application/json")
+
+	id := r.Header.Get("X-Request-Id")
+	if id == "" {
+		id = newRequestId()
+	}
+	w.Header().Set("X-Request-Id", id)
+	cache := &cacheUsage{}
+	ctx := context.WithValue(r.Context(), requestIdKey, id)
+	r = r.WithContext(context.WithValue(ctx, cacheUsageKey, cache))
+
+	log := logger.With("request_id", id, "method", r.Method, "route", r.URL.Path)
 	if pluginOptions.Debug.Request {
-		logRequest("REQUEST TO GO SERVER", r)
+		var body []byte
+		if r.Body != nil {
+			body, _ = io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		log.Debug("Request to Go server", "headers", redactHeaders(r.Header), "body", string(body))
 	}
-	lrw := &LoggingResponseWriter{ResponseWriter: w, body: &bytes.Buffer{}}
+
+	lrw := &LoggingResponseWriter{ResponseWriter: w, body: &bytes.Buffer{}, cache: cache}
 	l.handler.ServeHTTP(lrw, r)
-	resp := &http.Response{
-		Status:        http.StatusText(lrw.statusCode),
-		StatusCode:    lrw.statusCode,
-		Body:          io.NopCloser(bytes.NewBuffer(lrw.body.Bytes())), // Use the captured body
-		ContentLength: int64(lrw.body.Len()),
-		Header:        lrw.Header(),
-		Request:       r,
+
+	status := lrw.statusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	duration := time.Since(start)
+	metrics.observeRoute(r.Method, normalizeEndpoint(r.URL.Path), status, duration)
+	attrs := []any{"status", status, "duration_ms", duration.Milliseconds()}
+	if cache.servedStale() {
+		attrs = append(attrs, "cache", "stale")
+	}
+
+	switch {
+	case status >= 400:
+		var errResponse ErrorResponse
+		_ = json.Unmarshal(lrw.body.Bytes(), &errResponse)
+		attrs = append(attrs, "error", errResponse.Message, "details", errResponse.Details, "code", errResponse.Code)
+		if errResponse.GitlabStatus != 0 {
+			attrs = append(attrs, "gitlab_status", errResponse.GitlabStatus)
+		}
+		level := slog.LevelWarn
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		log.Log(r.Context(), level, "Request failed", attrs...)
+	default:
+		log.Debug("Request handled", attrs...)
 	}
+
 	if pluginOptions.Debug.Response {
-		logResponse("RESPONSE FROM GO SERVER", resp) //nolint:errcheck
+		log.Debug("Response from Go server", "headers", redactHeaders(lrw.Header()), "body", lrw.body.String())
 	}
 }
 
-func logRequest(prefix string, r *http.Request) {
-	file := openLogFile()
-	defer file.Close()
-	token := r.Header.Get("Private-Token")
-	r.Header.Set("Private-Token", "REDACTED")
-	res, err := httputil.DumpRequest(r, true)
-	if err != nil {
-		log.Fatalf("Error dumping request: %v", err)
-		os.Exit(1)
-	}
-	r.Header.Set("Private-Token", token)
-	fmt.Fprintf(file, "\n-- %s --\n%s\n", prefix, res) //nolint:errcheck
+/* loggingTransport logs every call to GitLab with its endpoint, status and duration, under the ID of the request that made it */
+type loggingTransport struct {
+	next http.RoundTripper
 }
 
-func logResponse(prefix string, r *http.Response) {
-	file := openLogFile()
-	defer file.Close()
+func (t loggingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	log := logger.With("request_id", requestId(r.Context()), "upstream_method", r.Method, "upstream", r.URL.Path)
 
-	res, err := httputil.DumpResponse(r, true)
+	if pluginOptions.Debug.GitlabRequest {
+		dump, _ := httputil.DumpRequestOut(redactedRequest(r), r.GetBody != nil)
+		log.Debug("Request to GitLab", "query", r.URL.RawQuery, "dump", string(dump))
+	}
+
+	res, err := t.next.RoundTrip(r)
 	if err != nil {
-		log.Fatalf("Error dumping response: %v", err)
-		os.Exit(1)
+		log.Error("Request to GitLab failed", "duration_ms", time.Since(start).Milliseconds(), "error", err.Error())
+		return res, err
+	}
+
+	attrs := []any{"status", res.StatusCode, "duration_ms", time.Since(start).Milliseconds()}
+	if res.StatusCode >= 500 {
+		log.Warn("Request to GitLab failed", attrs...)
+	} else {
+		log.Debug("Request to GitLab", attrs...)
+	}
+
+	if pluginOptions.Debug.GitlabResponse {
+		dump, _ := httputil.DumpResponse(res, true) // Restores the body for the client
+		log.Debug("Response from GitLab", "dump", string(dump))
 	}
 
-	fmt.Fprintf(file, "\n-- %s --\n%s\n", prefix, res) //nolint:errcheck
+	return res, nil
 }
 
-func openLogFile() *os.File {
-	file, err := os.OpenFile(pluginOptions.LogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		if os.IsNotExist(err) {
-			log.Printf("Log file %s does not exist", pluginOptions.LogPath)
-		} else if os.IsPermission(err) {
-			log.Printf("Permission denied for log file %s", pluginOptions.LogPath)
-		} else {
-			log.Printf("Error opening log file %s: %v", pluginOptions.LogPath, err)
+/* redactedRequest copies a request for dumping, with the sensitive headers replaced and the body rewound */
+func redactedRequest(r *http.Request) *http.Request {
+	c := r.Clone(r.Context())
+	for _, header := range sensitiveHeaders {
+		if c.Header.Get(header) != "" {
+			c.Header.Set(header, "REDACTED")
 		}
-
-		os.Exit(1)
 	}
-
-	return file
+	if r.GetBody != nil {
+		c.Body, _ = r.GetBody()
+	}
+	return c
 }
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* useTestLogger points the logger at a temporary file for one test and returns a function that reads its records */
+func useTestLogger(t *testing.T, configure func(*PluginOptions)) func() []map[string]any {
+	t.Helper()
+	originalOptions := pluginOptions
+	logPath := filepath.Join(t.TempDir(), "gitlab.nvim.log")
+	pluginOptions.LogPath = logPath
+	configure(&pluginOptions)
+	setupLogger()
+	t.Cleanup(func() {
+		pluginOptions = originalOptions
+		setupLogger()
+	})
+
+	return func() []map[string]any {
+		t.Helper()
+		file, err := os.Open(logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		var records []map[string]any
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var record map[string]any
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Fatalf("Log record is not JSON: %s", scanner.Text())
+			}
+			records = append(records, record)
+		}
+		return records
+	}
+}
+
+type fakeRoundTripper struct {
+	status int
+	err    error
+}
+
+func (f fakeRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &http.Response{StatusCode: f.status, Body: http.NoBody, Header: http.Header{}, Request: r}, nil
+}
+
+func TestLoggingServer(t *testing.T) {
+	t.Run("Logs only failed requests unless debugging", func(t *testing.T) {
+		records := useTestLogger(t, func(p *PluginOptions) {})
+		server := LoggingServer{handler: middleware(fakeHandler{}, withMethodCheck(http.MethodGet))}
+
+		server.ServeHTTP(httptest.NewRecorder(), makeRequest(t, http.MethodGet, "/foo", nil))
+		server.ServeHTTP(httptest.NewRecorder(), makeRequest(t, http.MethodPost, "/foo", nil))
+
+		logged := records()
+		assert(t, len(logged), 1)
+		assert(t, logged[0]["level"], any("WARN"))
+	})
+	t.Run("Logs every request with its ID, route, status and duration when debugging", func(t *testing.T) {
+		records := useTestLogger(t, func(p *PluginOptions) { p.Debug.Response = true })
+		server := LoggingServer{handler: middleware(fakeHandler{}, withMethodCheck(http.MethodGet))}
+
+		res := httptest.NewRecorder()
+		server.ServeHTTP(res, makeRequest(t, http.MethodGet, "/foo", nil))
+		res = httptest.NewRecorder()
+		server.ServeHTTP(res, makeRequest(t, http.MethodPost, "/foo", nil))
+
+		logged := []map[string]any{}
+		for _, record := range records() {
+			if record["msg"] != "Response from Go server" {
+				logged = append(logged, record)
+			}
+		}
+		assert(t, len(logged), 2)
+		assert(t, logged[0]["level"], any("DEBUG"))
+		assert(t, logged[0]["route"], any("/foo"))
+		assert(t, logged[0]["status"], any(float64(http.StatusOK)))
+		assert(t, logged[0]["request_id"] != "", true)
+		if _, ok := logged[0]["duration_ms"]; !ok {
+			t.Error("Expected a duration")
+		}
+		assert(t, logged[1]["level"], any("WARN"))
+		assert(t, logged[1]["error"], any("Invalid request type"))
+		assert(t, res.Code, http.StatusMethodNotAllowed)
+		assert(t, res.Header().Get("X-Request-Id"), logged[1]["request_id"].(string))
+	})
+	t.Run("Redacts the GitLab token and the server secret", func(t *testing.T) {
+		records := useTestLogger(t, func(p *PluginOptions) { p.Debug.Request = true })
+		server := LoggingServer{handler: fakeHandler{}}
+
+		request := makeRequest(t, http.MethodGet, "/mr/info", nil)
+		request.Header.Set("Private-Token", "glpat-token")
+		request.Header.Set("Authorization", "Bearer s3cret")
+		server.ServeHTTP(httptest.NewRecorder(), request)
+
+		logged := records()
+		assert(t, logged[0]["level"], any("DEBUG"))
+		for _, record := range logged {
+			content, _ := json.Marshal(record)
+			if strings.Contains(string(content), "glpat-token") || strings.Contains(string(content), "s3cret") {
+				t.Errorf("Log contains a secret: %s", content)
+			}
+		}
+		assert(t, request.Header.Get("Private-Token"), "glpat-token")
+	})
+	t.Run("Logs calls to GitLab under the ID of the request", func(t *testing.T) {
+		records := useTestLogger(t, func(p *PluginOptions) {})
+		client := &http.Client{Transport: loggingTransport{fakeRoundTripper{status: http.StatusBadGateway}}}
+
+		request := makeRequest(t, http.MethodGet, "https://gitlab.com/api/v4/projects/1", nil)
+		request = request.WithContext(contextWithRequestId(request, "abc"))
+		res, err := client.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		_, err = (&http.Client{Transport: loggingTransport{fakeRoundTripper{err: errors.New("refused")}}}).Get("https://gitlab.com/api/v4/user")
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+
+		logged := records()
+		assert(t, len(logged), 2)
+		assert(t, logged[0]["request_id"], any("abc"))
+		assert(t, logged[0]["upstream"], any("/api/v4/projects/1"))
+		assert(t, logged[0]["status"], any(float64(http.StatusBadGateway)))
+		assert(t, logged[0]["level"], any("WARN"))
+		assert(t, logged[1]["level"], any("ERROR"))
+	})
+}
+
+func contextWithRequestId(r *http.Request, id string) context.Context {
+	return context.WithValue(r.Context(), requestIdKey, id)
+}
+
+func TestRotatingFile(t *testing.T) {
+	t.Run("Rotates the file once it grows past the maximum size", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "gitlab.nvim.log")
+		f := &rotatingFile{path: path, maxSize: 10, backups: 2}
+		for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+			_, _ = f.Write([]byte(line))
+		}
+
+		current, _ := os.ReadFile(path)
+		previous, _ := os.ReadFile(path + ".1")
+		oldest, _ := os.ReadFile(path + ".2")
+		assert(t, string(current), "fourth\n")
+		assert(t, string(previous), "third\n")
+		assert(t, string(oldest), "second\n")
+		if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+			t.Error("Expected only two backups to be kept")
+		}
+	})
+	t.Run("Keeps the file readable by its owner only", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "gitlab.nvim.log")
+		_, _ = (&rotatingFile{path: path, maxSize: 10}).Write([]byte("record\n"))
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, info.Mode().Perm(), os.FileMode(0600))
+
+		older := filepath.Join(t.TempDir(), "gitlab.nvim.log")
+		_ = os.WriteFile(older, []byte{}, 0644)
+		_, _ = (&rotatingFile{path: older, maxSize: 10}).Write([]byte("record\n"))
+		info, err = os.Stat(older)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, info.Mode().Perm(), os.FileMode(0600))
+	})
+	t.Run("Drops records instead of failing when the file cannot be opened", func(t *testing.T) {
+		f := &rotatingFile{path: filepath.Join(t.TempDir(), "missing", "gitlab.nvim.log"), maxSize: 10}
+		n, err := f.Write([]byte("record\n"))
+		assert(t, err, nil)
+		assert(t, n, len("record\n"))
+	})
+}
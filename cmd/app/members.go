@@ -21,6 +21,10 @@ type projectMemberService struct {
 	client ProjectMemberLister
 }
 
+func (a projectMemberService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodGet: ProjectMembersResponse{}}
+}
+
 /* projectMembersHandler returns all members of the current Gitlab project */
 func (a projectMemberService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
@@ -30,7 +34,7 @@ func (a projectMemberService) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		},
 	}
 
-	projectMembers, res, err := a.client.ListAllProjectMembers(a.projectInfo.ProjectId, &projectMemberOptions)
+	projectMembers, res, err := a.client.ListAllProjectMembers(a.projectId(r), &projectMemberOptions, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not retrieve project members", http.StatusInternalServerError)
@@ -22,6 +22,10 @@ type mergeRequestAccepterService struct {
 	client MergeRequestAccepter
 }
 
+func (a mergeRequestAccepterService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPost: SuccessResponse{}}
+}
+
 /* acceptAndMergeHandler merges a given merge request into the target branch */
 func (a mergeRequestAccepterService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	payload := r.Context().Value(payload("payload")).(*AcceptMergeRequestRequest)
@@ -35,7 +39,7 @@ func (a mergeRequestAccepterService) ServeHTTP(w http.ResponseWriter, r *http.Re
 		opts.SquashCommitMessage = &payload.SquashMessage
 	}
 
-	_, res, err := a.client.AcceptMergeRequest(a.projectInfo.ProjectId, a.projectInfo.MergeId, &opts)
+	_, res, err := a.client.AcceptMergeRequest(a.projectId(r), a.mergeId(r), &opts, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not merge MR", http.StatusInternalServerError)
@@ -22,6 +22,10 @@ type mergeRequestListerService struct {
 	client MergeRequestLister
 }
 
+func (a mergeRequestListerService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPost: ListMergeRequestResponse{}}
+}
+
 // Lists all merge requests in Gitlab according to the provided filters
 func (a mergeRequestListerService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
@@ -35,7 +39,7 @@ func (a mergeRequestListerService) ServeHTTP(w http.ResponseWriter, r *http.Requ
 		payload.Scope = gitlab.Ptr("all")
 	}
 
-	mergeRequests, res, err := a.client.ListProjectMergeRequests(a.projectInfo.ProjectId, payload)
+	mergeRequests, res, err := a.client.ListProjectMergeRequests(a.projectId(r), payload, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Failed to list merge requests", http.StatusInternalServerError)
@@ -1,11 +1,11 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
-	"sync"
 
 	gitlab "gitlab.com/gitlab-org/api/client-go"
 )
@@ -19,6 +19,10 @@ type mergeRequestListerByUsernameService struct {
 	client MergeRequestListerByUsername
 }
 
+func (a mergeRequestListerByUsernameService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPost: ListMergeRequestResponse{}}
+}
+
 type MergeRequestByUsernameRequest struct {
 	UserId   int    `json:"user_id" validate:"required"`
 	Username string `json:"username" validate:"required"`
@@ -57,26 +61,16 @@ func (a mergeRequestListerByUsernameService) ServeHTTP(w http.ResponseWriter, r
 		err error
 	}
 
-	mrChan := make(chan apiResponse, len(payloads))
-	wg := sync.WaitGroup{}
-	go func() {
-		wg.Wait()
-		close(mrChan)
-	}()
-
-	for _, payload := range payloads {
-		wg.Add(1)
-		go func(p gitlab.ListProjectMergeRequestsOptions) {
-			defer wg.Done()
-			mrs, err := a.getMrs(&p)
-			mrChan <- apiResponse{mrs, err}
-		}(payload)
-	}
+	/* Every failure is reported, so the errors are collected rather than ending the fan-out */
+	responses, _ := fanOut(payloads, func(p gitlab.ListProjectMergeRequestsOptions) (apiResponse, error) {
+		mrs, err := a.getMrs(r.Context(), &p)
+		return apiResponse{mrs, err}, nil
+	})
 
 	var mergeRequests []*gitlab.BasicMergeRequest
 	existingIds := make(map[int64]bool)
 	var errs []error
-	for res := range mrChan {
+	for _, res := range responses {
 		if res.err != nil {
 			errs = append(errs, res.err)
 		} else {
@@ -115,8 +109,8 @@ func (a mergeRequestListerByUsernameService) ServeHTTP(w http.ResponseWriter, r
 	}
 }
 
-func (a mergeRequestListerByUsernameService) getMrs(payload *gitlab.ListProjectMergeRequestsOptions) ([]*gitlab.BasicMergeRequest, error) {
-	mrs, res, err := a.client.ListProjectMergeRequests(a.projectInfo.ProjectId, payload)
+func (a mergeRequestListerByUsernameService) getMrs(ctx context.Context, payload *gitlab.ListProjectMergeRequestsOptions) ([]*gitlab.BasicMergeRequest, error) {
+	mrs, res, err := a.client.ListProjectMergeRequests(a.state.ProjectId(), payload, gitlab.WithContext(ctx))
 	if err != nil {
 		return []*gitlab.BasicMergeRequest{}, err
 	}
@@ -0,0 +1,363 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+/* Upper bounds of the latency histogram buckets, in seconds */
+var latencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+/* metrics is shared by the GitLab client and the router, which both only live once per process */
+var metrics = newMetricsRegistry()
+
+/* timing collects the latencies of one series, such as the calls to one GitLab endpoint with one status */
+type timing struct {
+	count   int64
+	errors  int64
+	total   time.Duration
+	max     time.Duration
+	buckets []int64
+}
+
+func (t *timing) observe(d time.Duration, failed bool) {
+	t.count++
+	if failed {
+		t.errors++
+	}
+	t.total += d
+	t.max = max(t.max, d)
+	for i, bound := range latencyBuckets {
+		if d.Seconds() <= bound {
+			t.buckets[i]++
+		}
+	}
+}
+
+/* seriesKey identifies a series. Fields that do not apply to a kind of series are left empty. */
+type seriesKey struct {
+	method   string
+	endpoint string
+	status   int
+}
+
+/*
+metricsRegistry records how long the calls to GitLab, the routes of the server and local operations such
+as git commands take, so that slowness can be attributed to one of them.
+*/
+type metricsRegistry struct {
+	mu         sync.Mutex
+	started    time.Time
+	gitlab     map[seriesKey]*timing
+	retries    map[string]int64
+	routes     map[seriesKey]*timing
+	operations map[string]*timing
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		started:    time.Now(),
+		gitlab:     map[seriesKey]*timing{},
+		retries:    map[string]int64{},
+		routes:     map[seriesKey]*timing{},
+		operations: map[string]*timing{},
+	}
+}
+
+func (m *metricsRegistry) series(set map[seriesKey]*timing, key seriesKey) *timing {
+	t, ok := set[key]
+	if !ok {
+		t = &timing{buckets: make([]int64, len(latencyBuckets))}
+		set[key] = t
+	}
+	return t
+}
+
+/* observeGitlab records a call to GitLab. A status of 0 means the call failed before a response arrived. */
+func (m *metricsRegistry) observeGitlab(method string, endpoint string, status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series(m.gitlab, seriesKey{method, endpoint, status}).observe(d, status == 0 || status >= 500)
+}
+
+func (m *metricsRegistry) observeRetry(endpoint string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries[endpoint]++
+}
+
+func (m *metricsRegistry) observeRoute(method string, route string, status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series(m.routes, seriesKey{method, route, status}).observe(d, status >= 500)
+}
+
+/* time records how long a local operation takes, for instance with defer metrics.time("name")(&err) */
+func (m *metricsRegistry) time(name string) func(err *error) {
+	start := time.Now()
+	return func(err *error) {
+		d := time.Since(start)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		t, ok := m.operations[name]
+		if !ok {
+			t = &timing{buckets: make([]int64, len(latencyBuckets))}
+			m.operations[name] = t
+		}
+		t.observe(d, err != nil && *err != nil)
+	}
+}
+
+var (
+	numericSegment = regexp.MustCompile(`^\d+$`)
+	shaSegment     = regexp.MustCompile(`^[0-9a-f]{40}$`)
+)
+
+/*
+normalizeEndpoint replaces the IDs in a path with placeholders, so that all calls to an endpoint share one series.
+The segment after "projects" is always an ID, since projects can also be addressed by their URL-encoded path.
+*/
+func normalizeEndpoint(path string) string {
+	path = strings.TrimPrefix(path, "/api/v4")
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		switch {
+		case i > 0 && segments[i-1] == "projects":
+			segments[i] = ":id"
+		case numericSegment.MatchString(segment):
+			segments[i] = ":id"
+		case shaSegment.MatchString(segment):
+			segments[i] = ":sha"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+/* metricsTransport records every call to GitLab, including the ones that fail without a response */
+type metricsTransport struct {
+	next    http.RoundTripper
+	metrics *metricsRegistry
+}
+
+func (t metricsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	res, err := t.next.RoundTrip(r)
+	status := 0
+	if err == nil {
+		status = res.StatusCode
+	}
+	t.metrics.observeGitlab(r.Method, normalizeEndpoint(r.URL.EscapedPath()), status, time.Since(start))
+	return res, err
+}
+
+/* retryHook counts the attempts after the first one, which the GitLab client only makes when it retries a call */
+func (m *metricsRegistry) retryHook(_ retryablehttp.Logger, r *http.Request, attempt int) {
+	if attempt > 0 {
+		m.observeRetry(normalizeEndpoint(r.URL.EscapedPath()))
+	}
+}
+
+/* timedGitManager records how long the git commands behind the server's routes take */
+type timedGitManager struct {
+	git.GitManager
+	metrics *metricsRegistry
+}
+
+func (g timedGitManager) RefreshProjectInfo(remote string) (err error) {
+	defer g.metrics.time("git.RefreshProjectInfo")(&err)
+	return g.GitManager.RefreshProjectInfo(remote)
+}
+
+func (g timedGitManager) GetProjectUrlFromNativeGitCmd(remote string) (url string, err error) {
+	defer g.metrics.time("git.GetProjectUrlFromNativeGitCmd")(&err)
+	return g.GitManager.GetProjectUrlFromNativeGitCmd(remote)
+}
+
+func (g timedGitManager) GetCurrentBranchNameFromNativeGitCmd() (branch string, err error) {
+	defer g.metrics.time("git.GetCurrentBranchNameFromNativeGitCmd")(&err)
+	return g.GitManager.GetCurrentBranchNameFromNativeGitCmd()
+}
+
+func (g timedGitManager) GetLatestCommitOnRemote(remote string, branchName string) (commit string, err error) {
+	defer g.metrics.time("git.GetLatestCommitOnRemote")(&err)
+	return g.GitManager.GetLatestCommitOnRemote(remote, branchName)
+}
+
+type TimingMetric struct {
+	Method   string  `json:"method,omitempty"`
+	Endpoint string  `json:"endpoint,omitempty"`
+	Name     string  `json:"name,omitempty"`
+	Status   int     `json:"status,omitempty"`
+	Count    int64   `json:"count"`
+	Errors   int64   `json:"errors"`
+	TotalMs  float64 `json:"total_ms"`
+	AvgMs    float64 `json:"avg_ms"`
+	MaxMs    float64 `json:"max_ms"`
+}
+
+type RetryMetric struct {
+	Endpoint string `json:"endpoint"`
+	Count    int64  `json:"count"`
+}
+
+type MetricsResponse struct {
+	SuccessResponse
+	UptimeSeconds int64          `json:"uptime_seconds"`
+	Gitlab        []TimingMetric `json:"gitlab"`
+	Retries       []RetryMetric  `json:"retries"`
+	Routes        []TimingMetric `json:"routes"`
+	Operations    []TimingMetric `json:"operations"`
+}
+
+func newTimingMetric(t *timing) TimingMetric {
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	return TimingMetric{
+		Count:   t.count,
+		Errors:  t.errors,
+		TotalMs: ms(t.total),
+		AvgMs:   ms(t.total / time.Duration(max(t.count, 1))),
+		MaxMs:   ms(t.max),
+	}
+}
+
+func timingMetrics(set map[seriesKey]*timing) []TimingMetric {
+	result := []TimingMetric{}
+	for key, t := range set {
+		metric := newTimingMetric(t)
+		metric.Method, metric.Endpoint, metric.Status = key.method, key.endpoint, key.status
+		result = append(result, metric)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Endpoint != b.Endpoint {
+			return a.Endpoint < b.Endpoint
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Status < b.Status
+	})
+	return result
+}
+
+func (m *metricsRegistry) snapshot() MetricsResponse {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	response := MetricsResponse{
+		SuccessResponse: SuccessResponse{Message: "Metrics retrieved"},
+		UptimeSeconds:   int64(time.Since(m.started).Seconds()),
+		Gitlab:          timingMetrics(m.gitlab),
+		Retries:         []RetryMetric{},
+		Routes:          timingMetrics(m.routes),
+		Operations:      []TimingMetric{},
+	}
+	for endpoint, count := range m.retries {
+		response.Retries = append(response.Retries, RetryMetric{endpoint, count})
+	}
+	sort.Slice(response.Retries, func(i, j int) bool { return response.Retries[i].Endpoint < response.Retries[j].Endpoint })
+	for name, t := range m.operations {
+		metric := newTimingMetric(t)
+		metric.Name = name
+		response.Operations = append(response.Operations, metric)
+	}
+	sort.Slice(response.Operations, func(i, j int) bool { return response.Operations[i].Name < response.Operations[j].Name })
+	return response
+}
+
+/* writePrometheus writes the metrics in the Prometheus text exposition format */
+func (m *metricsRegistry) writePrometheus(w *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP gitlab_nvim_uptime_seconds Seconds since the server started.\n# TYPE gitlab_nvim_uptime_seconds gauge\n")
+	fmt.Fprintf(w, "gitlab_nvim_uptime_seconds %d\n", int64(time.Since(m.started).Seconds()))
+
+	writeHistograms(w, "gitlab_nvim_gitlab_request_duration_seconds", "Latency of calls to GitLab.", m.gitlab)
+	writeHistograms(w, "gitlab_nvim_route_duration_seconds", "Latency of the routes of the server.", m.routes)
+
+	fmt.Fprintf(w, "# HELP gitlab_nvim_gitlab_retries_total Calls to GitLab that were retried.\n# TYPE gitlab_nvim_gitlab_retries_total counter\n")
+	endpoints := make([]string, 0, len(m.retries))
+	for endpoint := range m.retries {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		fmt.Fprintf(w, "gitlab_nvim_gitlab_retries_total{endpoint=%q} %d\n", endpoint, m.retries[endpoint])
+	}
+
+	operations := map[seriesKey]*timing{}
+	for name, t := range m.operations {
+		operations[seriesKey{endpoint: name}] = t
+	}
+	writeHistograms(w, "gitlab_nvim_operation_duration_seconds", "Latency of local operations such as git commands.", operations)
+}
+
+func writeHistograms(w *strings.Builder, name string, help string, set map[seriesKey]*timing) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+
+	keys := make([]seriesKey, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+
+	for _, key := range keys {
+		t := set[key]
+		labels := fmt.Sprintf("name=%q", key.endpoint) // Operations only have a name
+		if key.method != "" {
+			labels = fmt.Sprintf("method=%q,endpoint=%q,status=\"%d\"", key.method, key.endpoint, key.status)
+		}
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, bound, t.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, t.count)
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, t.total.Seconds())
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, t.count)
+	}
+}
+
+type metricsService struct {
+	metrics *metricsRegistry
+}
+
+func (a metricsService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodGet: MetricsResponse{}}
+}
+
+/* ServeHTTP reports the recorded metrics as JSON, or in the Prometheus text format when asked for with ?format=prometheus or Accept: text/plain */
+func (a metricsService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("format") == "prometheus" || strings.HasPrefix(r.Header.Get("Accept"), "text/plain") {
+		var b strings.Builder
+		a.metrics.writePrometheus(&b)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(b.String()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(a.metrics.snapshot())
+	if err != nil {
+		handleError(w, err, "Could not encode response", http.StatusInternalServerError)
+	}
+}
+
+/* attachPprof mounts the Go profiler under /debug/pprof/ */
+func attachPprof(m *apiMux) {
+	m.HandleFunc("/debug/pprof/", pprof.Index)
+	m.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	m.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	m.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	m.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
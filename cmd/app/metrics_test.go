@@ -0,0 +1,113 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeEndpoint(t *testing.T) {
+	cases := map[string]string{
+		"/api/v4/projects/12/merge_requests/3/notes/44/award_emoji": "/projects/:id/merge_requests/:id/notes/:id/award_emoji",
+		"/api/v4/projects/group%2Fproject":                          "/projects/:id",
+		"/api/v4/user":                                              "/user",
+		"/mr/awardable/note/12":                                     "/mr/awardable/note/:id",
+		"/api/v4/projects/1/repository/commits/0123456789abcdef0123456789abcdef01234567": "/projects/:id/repository/commits/:sha",
+	}
+	for path, expected := range cases {
+		assert(t, normalizeEndpoint(path), expected)
+	}
+}
+
+func TestMetricsTransport(t *testing.T) {
+	t.Run("Records calls to GitLab by endpoint and status", func(t *testing.T) {
+		registry := newMetricsRegistry()
+		client := &http.Client{Transport: metricsTransport{fakeRoundTripper{status: http.StatusNotFound}, registry}}
+		for _, id := range []string{"1", "2"} {
+			res, err := client.Get("https://gitlab.com/api/v4/projects/" + id + "/merge_requests")
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+		}
+
+		failing := &http.Client{Transport: metricsTransport{fakeRoundTripper{err: errors.New("refused")}, registry}}
+		_, _ = failing.Get("https://gitlab.com/api/v4/user")
+
+		snapshot := registry.snapshot()
+		assert(t, len(snapshot.Gitlab), 2)
+		assert(t, snapshot.Gitlab[0].Endpoint, "/projects/:id/merge_requests")
+		assert(t, snapshot.Gitlab[0].Status, http.StatusNotFound)
+		assert(t, snapshot.Gitlab[0].Count, int64(2))
+		assert(t, snapshot.Gitlab[1].Endpoint, "/user")
+		assert(t, snapshot.Gitlab[1].Errors, int64(1))
+	})
+	t.Run("Counts retries but not first attempts", func(t *testing.T) {
+		registry := newMetricsRegistry()
+		request := makeRequest(t, http.MethodGet, "https://gitlab.com/api/v4/projects/1", nil)
+		for attempt := range 3 {
+			registry.retryHook(nil, request, attempt)
+		}
+		snapshot := registry.snapshot()
+		assert(t, len(snapshot.Retries), 1)
+		assert(t, snapshot.Retries[0], RetryMetric{Endpoint: "/projects/:id", Count: 2})
+	})
+}
+
+func TestMetricsHandler(t *testing.T) {
+	registry := newMetricsRegistry()
+	registry.observeRoute(http.MethodGet, "/mr/info", http.StatusOK, 30*time.Millisecond)
+	registry.observeGitlab(http.MethodGet, "/projects/:id", http.StatusOK, 2*time.Second)
+	func() (err error) {
+		defer registry.time("git.GetLatestCommitOnRemote")(&err)
+		return errors.New("no remote")
+	}()
+
+	t.Run("Reports metrics as JSON", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		metricsService{registry}.ServeHTTP(res, makeRequest(t, http.MethodGet, "/debug/metrics", nil))
+
+		var data MetricsResponse
+		err := json.Unmarshal(res.Body.Bytes(), &data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, data.Routes[0].Endpoint, "/mr/info")
+		assert(t, data.Routes[0].AvgMs, float64(30))
+		assert(t, data.Gitlab[0].MaxMs, float64(2000))
+		assert(t, data.Operations[0].Name, "git.GetLatestCommitOnRemote")
+		assert(t, data.Operations[0].Errors, int64(1))
+	})
+	t.Run("Reports metrics in the Prometheus text format", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		metricsService{registry}.ServeHTTP(res, makeRequest(t, http.MethodGet, "/debug/metrics?format=prometheus", nil))
+
+		body := res.Body.String()
+		assert(t, strings.HasPrefix(res.Header().Get("Content-Type"), "text/plain"), true)
+		for _, line := range []string{
+			"# TYPE gitlab_nvim_gitlab_request_duration_seconds histogram",
+			`gitlab_nvim_gitlab_request_duration_seconds_bucket{method="GET",endpoint="/projects/:id",status="200",le="1"} 0`,
+			`gitlab_nvim_gitlab_request_duration_seconds_bucket{method="GET",endpoint="/projects/:id",status="200",le="2.5"} 1`,
+			`gitlab_nvim_route_duration_seconds_count{method="GET",endpoint="/mr/info",status="200"} 1`,
+			`gitlab_nvim_operation_duration_seconds_count{name="git.GetLatestCommitOnRemote"} 1`,
+		} {
+			if !strings.Contains(body, line+"\n") {
+				t.Errorf("Expected output to contain %s, got:\n%s", line, body)
+			}
+		}
+	})
+	t.Run("Records the routes of the server", func(t *testing.T) {
+		server := LoggingServer{handler: fakeHandler{}}
+		server.ServeHTTP(httptest.NewRecorder(), makeRequest(t, http.MethodGet, "/mr/awardable/note/42", nil))
+
+		found := false
+		for _, route := range metrics.snapshot().Routes {
+			found = found || route.Endpoint == "/mr/awardable/note/:id"
+		}
+		assert(t, found, true)
+	})
+}
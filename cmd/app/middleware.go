@@ -2,14 +2,19 @@ package app
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
 	gitlab "gitlab.com/gitlab-org/api/client-go"
 )
 
@@ -17,13 +22,66 @@ type mw func(http.Handler) http.Handler
 
 type payload string
 
+type contextKey string
+
+/* The IID of the merge request that a request explicitly addresses, as an int64 */
+const mergeRequestIID = contextKey("merge_request_iid")
+
+/* The merge request and project that withMr resolved for a request */
+const currentMergeRequestKey = contextKey("current_merge_request")
+
+/*
+route is a handler wrapped in middleware, along with the methods and payloads that the middleware accepts
+and the responses that the handler answers with
+*/
+type route struct {
+	http.Handler
+	methods   []string
+	payloads  methodToPayload
+	responses methodToResponse
+	public    bool
+}
+
+/*
+routeDescriber is implemented by handlers whose middleware declares what a route accepts, such as its methods,
+and by services that declare what they answer with
+*/
+type routeDescriber interface {
+	describe(r *route)
+}
+
+/* methodToResponse maps each method of a route to an example of the body it answers with */
+type methodToResponse map[string]any
+
+/* eventStream is the response of a route that streams server-sent events, each carrying the given data */
+type eventStream struct {
+	event any
+}
+
+/* plainText is the response of a route that answers with plain text instead of JSON */
+type plainText struct{}
+
+/* describedHandler pairs a middleware's handler with the middleware, so that it can describe the route */
+type describedHandler struct {
+	http.HandlerFunc
+	routeDescriber
+}
+
 // Wraps a series of middleware around the base handler. Functions are called from bottom to top.
 // The middlewares should call the serveHTTP method on their http.Handler argument to pass along the request.
-func middleware(h http.Handler, middlewares ...mw) http.HandlerFunc {
+func middleware(h http.Handler, middlewares ...mw) route {
+	r := route{}
+	if d, ok := h.(routeDescriber); ok {
+		d.describe(&r)
+	}
 	for _, middleware := range middlewares {
 		h = middleware(h)
+		if d, ok := h.(routeDescriber); ok {
+			d.describe(&r)
+		}
 	}
-	return h.ServeHTTP
+	r.Handler = h
+	return r
 }
 
 var validate = validator.New()
@@ -44,7 +102,7 @@ type validatorMiddleware struct {
 // Validates the fields in a payload and then attaches the validated payload to the request context so that
 // subsequent handlers can use it.
 func (p validatorMiddleware) handle(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return describedHandler{func(w http.ResponseWriter, r *http.Request) {
 
 		constructor, exists := p.methodToPayload[r.Method]
 		if !exists { // If no payload to validate for this method type...
@@ -85,7 +143,11 @@ func (p validatorMiddleware) handle(next http.Handler) http.Handler {
 		r = r.WithContext(ctx)
 
 		next.ServeHTTP(w, r)
-	})
+	}, p}
+}
+
+func (p validatorMiddleware) describe(r *route) {
+	r.payloads = p.methodToPayload
 }
 
 func withPayloadValidation(mtp methodToPayload) mw {
@@ -97,58 +159,213 @@ type withMrMiddleware struct {
 	client MergeRequestLister
 }
 
-// Gets the current merge request ID and attaches it to the projectInfo
-func (m withMrMiddleware) handle(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// If the merge request is already attached, skip the middleware logic
-		if m.data.projectInfo.MergeId == 0 {
-			options := gitlab.ListProjectMergeRequestsOptions{
-				Scope:        gitlab.Ptr("all"),
-				SourceBranch: &m.data.gitInfo.BranchName,
-			}
+/* mergeRequestLookupError describes why the current merge request could not be resolved */
+type mergeRequestLookupError struct {
+	err     error
+	message string
+	status  int
+	code    ErrorCode
+}
 
-			if pluginOptions.ChosenMrIID != 0 {
-				options.IIDs = gitlab.Ptr([]int64{pluginOptions.ChosenMrIID})
-			}
+func (e mergeRequestLookupError) Error() string {
+	return e.err.Error()
+}
 
-			mergeRequests, _, err := m.client.ListProjectMergeRequests(m.data.projectInfo.ProjectId, &options)
-			if err != nil {
-				handleError(w, fmt.Errorf("failed to list merge requests: %w", err), "Failed to list merge requests", http.StatusInternalServerError)
-				return
-			}
+func (e mergeRequestLookupError) Unwrap() error {
+	return e.err
+}
 
-			if len(mergeRequests) == 0 {
-				err := fmt.Errorf("branch '%s' does not have any merge requests", m.data.gitInfo.BranchName)
-				handleError(w, err, "No MRs Found", http.StatusNotFound)
-				return
-			}
+func (e mergeRequestLookupError) errorCode() ErrorCode {
+	return e.code
+}
 
-			if len(mergeRequests) > 1 {
-				err := errors.New("please call gitlab.choose_merge_request()")
-				handleError(w, err, "Multiple MRs found", http.StatusBadRequest)
+/*
+Resolves the current merge request ID into the session state. Concurrent first requests share one lookup. The
+merge request and its project are kept in the request context, so the handler uses them even if the session
+changes while it runs.
+*/
+func (m withMrMiddleware) handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// If the request addresses a merge request by IID, only the project is taken from the session
+		if iid, addressed := r.Context().Value(mergeRequestIID).(int64); addressed {
+			mr := currentMergeRequest{m.data.state.ProjectId(), iid}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), currentMergeRequestKey, mr)))
+			return
+		}
+
+		/* The lookup is shared with concurrent requests, so it must outlive this one if it is cancelled */
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), defaultRouteTimeout)
+		mr, err := m.data.state.ResolveMergeRequest(func(query mergeIdQuery) (int64, error) { return findMergeId(ctx, m.client, query) })
+		cancel()
+		if err != nil {
+			var lookupErr mergeRequestLookupError
+			if errors.As(err, &lookupErr) {
+				handleError(w, lookupErr, lookupErr.message, lookupErr.status)
 				return
 			}
-
-			mergeIdInt := mergeRequests[0].IID
-			m.data.projectInfo.MergeId = mergeIdInt
+			handleError(w, err, "Failed to list merge requests", http.StatusInternalServerError)
+			return
 		}
 
 		// Call the next handler if middleware succeeds
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), currentMergeRequestKey, mr)))
 	})
 }
 
+/* findMergeId looks up the single open merge request for the branch, or the chosen one if there are several */
+func findMergeId(ctx context.Context, client MergeRequestLister, query mergeIdQuery) (int64, error) {
+	options := gitlab.ListProjectMergeRequestsOptions{
+		Scope:        gitlab.Ptr("all"),
+		SourceBranch: &query.BranchName,
+	}
+
+	if query.ChosenMrIID != 0 {
+		options.IIDs = gitlab.Ptr([]int64{query.ChosenMrIID})
+	}
+
+	mergeRequests, _, err := client.ListProjectMergeRequests(query.ProjectId, &options, gitlab.WithContext(ctx))
+	if err != nil {
+		return 0, mergeRequestLookupError{fmt.Errorf("failed to list merge requests: %w", err), "Failed to list merge requests", http.StatusInternalServerError, ""}
+	}
+
+	if len(mergeRequests) == 0 {
+		err := fmt.Errorf("branch '%s' does not have any merge requests", query.BranchName)
+		return 0, mergeRequestLookupError{err, "No MRs Found", http.StatusNotFound, CodeMrNotFound}
+	}
+
+	if len(mergeRequests) > 1 {
+		err := errors.New("please call gitlab.choose_merge_request()")
+		return 0, mergeRequestLookupError{err, "Multiple MRs found", http.StatusBadRequest, CodeMultipleMrs}
+	}
+
+	return mergeRequests[0].IID, nil
+}
+
 // Att
 func withMr(data data, client MergeRequestLister) mw {
 	return withMrMiddleware{data, client}.handle
 }
 
+var mrPathPattern = regexp.MustCompile(`^/mr/(\d+)(/.*)$`)
+
+type mrAddressingMiddleware struct{}
+
+/*
+Lets every merge request route address a specific merge request rather than the current one, either as
+/mr/{iid}/discussions/list or as /mr/discussions/list?iid={iid}. The IID is attached to the request context
+and the path is rewritten to the plain route, so the plain paths remain aliases for the current merge request.
+*/
+func (m mrAddressingMiddleware) handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/mr/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		path := r.URL.Path
+		iid := r.URL.Query().Get("iid")
+		if matches := mrPathPattern.FindStringSubmatch(path); matches != nil {
+			iid = matches[1]
+			path = "/mr" + matches[2]
+		}
+
+		if iid == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		mergeId, err := strconv.ParseInt(iid, 10, 64)
+		if err != nil || mergeId <= 0 {
+			handleError(w, InvalidRequestError{fmt.Sprintf("invalid merge request IID '%s'", iid)}, "Invalid merge request IID", http.StatusBadRequest)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), mergeRequestIID, mergeId))
+		u := *r.URL
+		u.Path = path
+		u.RawPath = ""
+		r.URL = &u
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func withMrAddressing() mw {
+	return mrAddressingMiddleware{}.handle
+}
+
+type headCheckMiddleware struct {
+	watcher *git.HeadWatcher
+}
+
+// Checks whether HEAD moved to another branch before handling a request, so that no handler answers for the old branch
+func (m headCheckMiddleware) handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.watcher != nil {
+			_ = m.watcher.Check() // If HEAD cannot be read, keep serving the last known branch
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func withHeadCheck(watcher *git.HeadWatcher) mw {
+	return headCheckMiddleware{watcher}.handle
+}
+
+/* How long a route may take unless the plugin options set a timeout for it, or a "default" one */
+const defaultRouteTimeout = 30 * time.Second
+
+/* Routes that need a different timeout by default. Zero means no timeout, as for the stream of /events. */
+var routeTimeouts = map[string]time.Duration{
+	"/events":              0,
+	"/attachment":          2 * time.Minute,
+	"/job":                 time.Minute,
+	"/shutdown":            0,
+	"/debug/pprof/profile": 0,
+	"/debug/pprof/trace":   0,
+}
+
+/* routeTimeout returns the timeout of a route, by the pattern it is registered with */
+func routeTimeout(pattern string) time.Duration {
+	if seconds, ok := pluginOptions.Timeouts[pattern]; ok {
+		return time.Duration(seconds) * time.Second
+	}
+	if timeout, ok := routeTimeouts[pattern]; ok {
+		return timeout
+	}
+	if seconds, ok := pluginOptions.Timeouts["default"]; ok {
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultRouteTimeout
+}
+
+type timeoutMiddleware struct {
+	mux *apiMux
+}
+
+// Cancels the request context, and with it the calls to GitLab, once the route runs out of time
+func (m timeoutMiddleware) handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := m.mux.Handler(r)
+		if timeout := routeTimeout(pattern); timeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func withTimeouts(mux *apiMux) mw {
+	return timeoutMiddleware{mux}.handle
+}
+
 type methodMiddleware struct {
 	methods []string
 }
 
 func (m methodMiddleware) handle(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return describedHandler{func(w http.ResponseWriter, r *http.Request) {
 		method := r.Method
 		for _, acceptableMethod := range m.methods {
 			if method == acceptableMethod {
@@ -159,27 +376,57 @@ func (m methodMiddleware) handle(next http.Handler) http.Handler {
 
 		w.Header().Set("Access-Control-Allow-Methods", http.MethodPut)
 		handleError(w, InvalidRequestError{fmt.Sprintf("Expected: %s", strings.Join(m.methods, "; "))}, "Invalid request type", http.StatusMethodNotAllowed)
-	})
+	}, m}
+}
+
+func (m methodMiddleware) describe(r *route) {
+	r.methods = m.methods
 }
 
 func withMethodCheck(methods ...string) mw {
 	return methodMiddleware{methods: methods}.handle
 }
 
-// Helper function to format validation errors into more readable strings
-func formatValidationErrors(errs validator.ValidationErrors) error {
-	var s strings.Builder
-	for i, e := range errs {
-		if i > 0 {
-			s.WriteString("; ")
+type secretMiddleware struct {
+	secret string
+	exempt []string
+}
+
+// Rejects any request that does not carry the per-session secret as a bearer token, except for the exempt paths
+func (m secretMiddleware) handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if Contains(m.exempt, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
 		}
+
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if m.secret == "" || !found || subtle.ConstantTimeCompare([]byte(token), []byte(m.secret)) != 1 {
+			handleError(w, errors.New("missing or invalid server secret"), "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func withSecretCheck(secret string, exempt ...string) mw {
+	return secretMiddleware{secret: secret, exempt: exempt}.handle
+}
+
+// Helper function to format validation errors into more readable strings, keeping the failed fields for clients
+func formatValidationErrors(errs validator.ValidationErrors) error {
+	fields := make([]FieldError, 0, len(errs))
+	for _, e := range errs {
+		field := FieldError{Field: e.Field(), Tag: e.Tag(), Param: e.Param()}
 		switch e.Tag() {
 		case "required":
-			s.WriteString(fmt.Sprintf("%s is required", e.Field()))
+			field.Message = fmt.Sprintf("%s is required", e.Field())
 		default:
-			s.WriteString(fmt.Sprintf("The field '%s' failed on validation on the '%s' tag", e.Field(), e.Tag()))
+			field.Message = fmt.Sprintf("The field '%s' failed on validation on the '%s' tag", e.Field(), e.Tag())
 		}
+		fields = append(fields, field)
 	}
 
-	return errors.New(s.String())
+	return validationError{fields}
 }
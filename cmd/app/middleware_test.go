@@ -2,10 +2,16 @@ package app
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
 )
 
 type FakePayload struct {
@@ -51,44 +57,52 @@ func TestMethodMiddleware(t *testing.T) {
 }
 
 func TestWithMrMiddleware(t *testing.T) {
-	t.Run("Loads an MR ID into the projectInfo", func(t *testing.T) {
+	t.Run("Loads an MR ID into the session state", func(t *testing.T) {
 		request := makeRequest(t, http.MethodGet, "/foo", nil)
-		d := data{
-			projectInfo: &ProjectInfo{},
-			gitInfo:     &git.GitData{BranchName: "foo"},
-		}
+		d := data{state: newSessionState(ProjectInfo{}, git.GitData{BranchName: "foo"})}
 		mw := withMr(d, fakeMergeRequestLister{})
 		handler := middleware(fakeHandler{}, mw)
 		getSuccessData(t, handler, request)
-		if d.projectInfo.MergeId != 10 {
+		if d.state.MergeId() != 10 {
 			t.FailNow()
 		}
 	})
 	t.Run("Handles when there are no MRs", func(t *testing.T) {
 		request := makeRequest(t, http.MethodGet, "/foo", nil)
-		d := data{
-			projectInfo: &ProjectInfo{},
-			gitInfo:     &git.GitData{BranchName: "foo"},
-		}
+		d := data{state: newSessionState(ProjectInfo{}, git.GitData{BranchName: "foo"})}
 		mw := withMr(d, fakeMergeRequestLister{emptyResponse: true})
 		handler := middleware(fakeHandler{}, mw)
 		data, status := getFailData(t, handler, request)
 		assert(t, status, http.StatusNotFound)
 		assert(t, data.Message, "No MRs Found")
 		assert(t, data.Details, "branch 'foo' does not have any merge requests")
+		assert(t, data.Code, CodeMrNotFound)
 	})
 	t.Run("Handles when there are too many MRs", func(t *testing.T) {
 		request := makeRequest(t, http.MethodGet, "/foo", nil)
-		d := data{
-			projectInfo: &ProjectInfo{},
-			gitInfo:     &git.GitData{BranchName: "foo"},
-		}
+		d := data{state: newSessionState(ProjectInfo{}, git.GitData{BranchName: "foo"})}
 		mw := withMr(d, fakeMergeRequestLister{multipleMrs: true})
 		handler := middleware(fakeHandler{}, mw)
 		data, status := getFailData(t, handler, request)
 		assert(t, status, http.StatusBadRequest)
 		assert(t, data.Message, "Multiple MRs found")
 		assert(t, data.Details, "please call gitlab.choose_merge_request()")
+		assert(t, data.Code, CodeMultipleMrs)
+	})
+	t.Run("Keeps the resolved merge request when the session changes before the handler runs", func(t *testing.T) {
+		request := makeRequest(t, http.MethodGet, "/foo", nil)
+		d := data{state: newSessionState(ProjectInfo{ProjectId: "1"}, git.GitData{BranchName: "foo"})}
+		var projectId string
+		var mergeId int64
+		handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d.state.Update(sessionUpdate{project: &projectSwitch{"upstream", git.GitData{BranchName: "bar"}, "2"}})
+			projectId, mergeId = d.projectId(r), d.mergeId(r)
+			fakeHandler{}.ServeHTTP(w, r)
+		}), withMr(d, fakeMergeRequestLister{}))
+		getSuccessData(t, handler, request)
+		assert(t, projectId, "1")
+		assert(t, mergeId, int64(10))
+		assert(t, d.state.MergeId(), int64(0))
 	})
 }
 
@@ -102,6 +116,10 @@ func TestValidatorMiddleware(t *testing.T) {
 		assert(t, data.Message, "Invalid payload")
 		assert(t, data.Details, "Foo is required")
 		assert(t, status, http.StatusBadRequest)
+		assert(t, data.Code, CodeValidationFailed)
+		assert(t, len(data.Fields), 1)
+		assert(t, data.Fields[0].Field, "Foo")
+		assert(t, data.Fields[0].Tag, "required")
 	})
 	t.Run("Should allow valid payload through", func(t *testing.T) {
 		request := makeRequest(t, http.MethodPost, "/foo", FakePayload{Foo: "Some payload"})
@@ -112,3 +130,180 @@ func TestValidatorMiddleware(t *testing.T) {
 		assert(t, data.Message, "Some message")
 	})
 }
+
+func TestSecretMiddleware(t *testing.T) {
+	t.Run("Rejects a request without the secret", func(t *testing.T) {
+		request := makeRequest(t, http.MethodGet, "/foo", nil)
+		handler := middleware(fakeHandler{}, withSecretCheck("s3cret"))
+		data, status := getFailData(t, handler, request)
+		assert(t, status, http.StatusUnauthorized)
+		assert(t, data.Message, "Unauthorized")
+		assert(t, data.Details, "missing or invalid server secret")
+	})
+	t.Run("Rejects a request with the wrong secret", func(t *testing.T) {
+		request := makeRequest(t, http.MethodGet, "/foo", nil)
+		request.Header.Set("Authorization", "Bearer wrong")
+		handler := middleware(fakeHandler{}, withSecretCheck("s3cret"))
+		_, status := getFailData(t, handler, request)
+		assert(t, status, http.StatusUnauthorized)
+	})
+	t.Run("Rejects every request when no secret is configured", func(t *testing.T) {
+		request := makeRequest(t, http.MethodGet, "/foo", nil)
+		request.Header.Set("Authorization", "Bearer ")
+		handler := middleware(fakeHandler{}, withSecretCheck(""))
+		_, status := getFailData(t, handler, request)
+		assert(t, status, http.StatusUnauthorized)
+	})
+	t.Run("Allows a request with the secret through", func(t *testing.T) {
+		request := makeRequest(t, http.MethodGet, "/foo", nil)
+		request.Header.Set("Authorization", "Bearer s3cret")
+		handler := middleware(fakeHandler{}, withSecretCheck("s3cret"))
+		data := getSuccessData(t, handler, request)
+		assert(t, data.Message, "Some message")
+	})
+	t.Run("Allows exempt paths through without the secret", func(t *testing.T) {
+		request := makeRequest(t, http.MethodGet, "/ping", nil)
+		handler := middleware(fakeHandler{}, withSecretCheck("s3cret", "/ping"))
+		data := getSuccessData(t, handler, request)
+		assert(t, data.Message, "Some message")
+	})
+}
+
+/* mergeIdHandler responds with the merge request IID that a service would use for the request */
+type mergeIdHandler struct {
+	data
+	path *string
+}
+
+func (h mergeIdHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	*h.path = r.URL.Path
+	w.WriteHeader(http.StatusOK)
+	j, _ := json.Marshal(SuccessResponse{Message: fmt.Sprint(h.mergeId(r))})
+	w.Write(j) // nolint
+}
+
+func TestMrAddressingMiddleware(t *testing.T) {
+	d := data{state: newSessionState(ProjectInfo{MergeId: 10}, git.GitData{})}
+	t.Run("Uses the current merge request for plain paths", func(t *testing.T) {
+		var path string
+		request := makeRequest(t, http.MethodGet, "/mr/info", nil)
+		data := getSuccessData(t, middleware(mergeIdHandler{d, &path}, withMrAddressing()), request)
+		assert(t, data.Message, "10")
+		assert(t, path, "/mr/info")
+	})
+	t.Run("Addresses a merge request by path", func(t *testing.T) {
+		var path string
+		request := makeRequest(t, http.MethodPost, "/mr/42/discussions/list", nil)
+		data := getSuccessData(t, middleware(mergeIdHandler{d, &path}, withMrAddressing()), request)
+		assert(t, data.Message, "42")
+		assert(t, path, "/mr/discussions/list")
+	})
+	t.Run("Keeps trailing IDs on prefix routes", func(t *testing.T) {
+		var path string
+		request := makeRequest(t, http.MethodDelete, "/mr/42/draft_notes/7", nil)
+		data := getSuccessData(t, middleware(mergeIdHandler{d, &path}, withMrAddressing()), request)
+		assert(t, data.Message, "42")
+		assert(t, path, "/mr/draft_notes/7")
+	})
+	t.Run("Addresses a merge request by query parameter", func(t *testing.T) {
+		var path string
+		request := makeRequest(t, http.MethodGet, "/mr/info?iid=43", nil)
+		data := getSuccessData(t, middleware(mergeIdHandler{d, &path}, withMrAddressing()), request)
+		assert(t, data.Message, "43")
+		assert(t, path, "/mr/info")
+	})
+	t.Run("Rejects an invalid IID", func(t *testing.T) {
+		var path string
+		request := makeRequest(t, http.MethodGet, "/mr/info?iid=abc", nil)
+		data, status := getFailData(t, middleware(mergeIdHandler{d, &path}, withMrAddressing()), request)
+		assert(t, status, http.StatusBadRequest)
+		assert(t, data.Message, "Invalid merge request IID")
+	})
+	t.Run("Does not look up or cache the current merge request when one is addressed", func(t *testing.T) {
+		var path string
+		d := data{state: newSessionState(ProjectInfo{}, git.GitData{BranchName: "foo"})}
+		request := makeRequest(t, http.MethodGet, "/mr/42/info", nil)
+		handler := middleware(mergeIdHandler{d, &path}, withMr(d, fakeMergeRequestLister{emptyResponse: true}), withMrAddressing())
+		data := getSuccessData(t, handler, request)
+		assert(t, data.Message, "42")
+		assert(t, d.state.MergeId(), int64(0))
+	})
+}
+
+type fakeHeadLocator struct {
+	headPath string
+}
+
+func (f fakeHeadLocator) GetHeadPathFromNativeGitCmd() (string, error) {
+	return f.headPath, nil
+}
+
+func (f fakeHeadLocator) GetCurrentBranchNameFromNativeGitCmd() (string, error) {
+	head, err := os.ReadFile(f.headPath)
+	return strings.TrimPrefix(string(head), "ref: refs/heads/"), err
+}
+
+func TestHeadCheckMiddleware(t *testing.T) {
+	t.Run("Switches the branch before the request is handled", func(t *testing.T) {
+		locator := fakeHeadLocator{filepath.Join(t.TempDir(), "HEAD")}
+		_ = os.WriteFile(locator.headPath, []byte("ref: refs/heads/main"), 0644)
+		watcher, err := git.NewHeadWatcher(locator)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gitInfo := &git.GitData{BranchName: "main"}
+		watcher.OnChange(func(c git.BranchChange) { gitInfo.BranchName = c.To })
+		_ = os.WriteFile(locator.headPath, []byte("ref: refs/heads/feature"), 0644)
+
+		var seen string
+		handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = gitInfo.BranchName
+			fakeHandler{}.ServeHTTP(w, r)
+		}), withHeadCheck(watcher))
+		getSuccessData(t, handler, makeRequest(t, http.MethodGet, "/foo", nil))
+		assert(t, seen, "feature")
+	})
+	t.Run("Passes requests through without a watcher", func(t *testing.T) {
+		handler := middleware(fakeHandler{}, withHeadCheck(nil))
+		data := getSuccessData(t, handler, makeRequest(t, http.MethodGet, "/foo", nil))
+		assert(t, data.Message, "Some message")
+	})
+}
+
+/* slowMergeRequestGetter only answers once the context it was given is done */
+type slowMergeRequestGetter struct{}
+
+func (f slowMergeRequestGetter) GetMergeRequest(pid interface{}, mergeRequest int64, opt *gitlab.GetMergeRequestsOptions, options ...gitlab.RequestOptionFunc) (*gitlab.MergeRequest, *gitlab.Response, error) {
+	ctx := contextFromOptions(options)
+	<-ctx.Done()
+	return nil, nil, ctx.Err()
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	originalOptions := pluginOptions
+	t.Cleanup(func() { pluginOptions = originalOptions })
+
+	t.Run("Cancels the calls to GitLab once the route runs out of time", func(t *testing.T) {
+		pluginOptions.Timeouts = map[string]int{"default": 1}
+		m := newApiMux()
+		m.Handle("/mr/info", middleware(infoService{testProjectData, slowMergeRequestGetter{}}, withMethodCheck(http.MethodGet)))
+		routeTimeouts["/mr/info"] = 10 * time.Millisecond
+		t.Cleanup(func() { delete(routeTimeouts, "/mr/info") })
+
+		data, status := getFailData(t, middleware(m, withTimeouts(m)), makeRequest(t, http.MethodGet, "/mr/info", nil))
+		assert(t, status, http.StatusGatewayTimeout)
+		assert(t, data.Code, CodeUpstreamTimeout)
+		assert(t, data.Message, "Could not get project info")
+	})
+	t.Run("Reads timeouts from the plugin options before the defaults", func(t *testing.T) {
+		pluginOptions.Timeouts = map[string]int{"/job": 5, "default": 10}
+		assert(t, routeTimeout("/job"), 5*time.Second)
+		assert(t, routeTimeout("/events"), time.Duration(0))
+		assert(t, routeTimeout("/mr/info"), 10*time.Second)
+
+		pluginOptions.Timeouts = nil
+		assert(t, routeTimeout("/mr/info"), defaultRouteTimeout)
+		assert(t, routeTimeout("/attachment"), 2*time.Minute)
+	})
+}
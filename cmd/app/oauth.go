@@ -0,0 +1,271 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+/* Used when the OAuth settings leave the scopes out */
+var defaultOAuthScopes = []string{"api"}
+
+/* The OAuth token source of the client, when the plugin signs in with OAuth instead of an access token */
+var oauth *oauthTokenSource
+
+/* newOAuthConfig describes the OAuth application of a Gitlab instance, which must allow the device authorization grant */
+func newOAuthConfig(gitlabUrl string) *oauth2.Config {
+	settings := pluginOptions.Auth.OAuth
+	scopes := settings.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultOAuthScopes
+	}
+	return &oauth2.Config{
+		ClientID: settings.ClientId,
+		Scopes:   scopes,
+		Endpoint: oauth2.Endpoint{
+			DeviceAuthURL: gitlabUrl + "/oauth/authorize_device",
+			TokenURL:      gitlabUrl + "/oauth/token",
+			AuthStyle:     oauth2.AuthStyleInParams,
+		},
+	}
+}
+
+/* oauthStore keeps the OAuth token of a Gitlab instance in a file that only the user may read */
+type oauthStore struct {
+	path string
+}
+
+/* newOAuthStore uses the configured token path, or a file per Gitlab host in the user's config directory */
+func newOAuthStore(gitlabUrl string) (oauthStore, error) {
+	if path := pluginOptions.Auth.OAuth.TokenPath; path != "" {
+		return oauthStore{path}, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return oauthStore{}, fmt.Errorf("could not find a directory for the OAuth token: %w", err)
+	}
+	host := "unknown"
+	if u, err := url.Parse(gitlabUrl); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	return oauthStore{filepath.Join(dir, "gitlab.nvim", "oauth", url.PathEscape(host)+".json")}, nil
+}
+
+func (s oauthStore) load() (*oauth2.Token, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		logger.Warn("OAuth token file could be read by other users, restricting it", "file", s.path, "mode", info.Mode().Perm().String())
+		if err := os.Chmod(s.path, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal(content, &token); err != nil {
+		return nil, fmt.Errorf("could not read OAuth token file %s: %w", s.path, err)
+	}
+	return &token, nil
+}
+
+/* save writes the token through a temporary file, which is created readable by the user alone, so that a crash never leaves half a token behind */
+func (s oauthStore) save(token *oauth2.Token) error {
+	content, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nolint
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close() // nolint
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+/*
+oauthTokenSource hands out the OAuth access token to the client. It refreshes the token when it expires or when
+Gitlab turns it down, and saves every new token, since Gitlab replaces the refresh token on every refresh.
+*/
+type oauthTokenSource struct {
+	mu     sync.Mutex
+	config *oauth2.Config
+	ctx    context.Context
+	store  oauthStore
+	token  *oauth2.Token
+}
+
+func (s *oauthTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.Valid() {
+		return s.token, nil
+	}
+	return s.refresh()
+}
+
+/* rejected refreshes the token after Gitlab turned down accessToken, unless a concurrent call did so already */
+func (s *oauthTokenSource) rejected(accessToken string) (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.Valid() && s.token.AccessToken != accessToken {
+		return s.token, nil
+	}
+	return s.refresh()
+}
+
+/* refresh trades the refresh token for a new token. Callers hold the lock. */
+func (s *oauthTokenSource) refresh() (*oauth2.Token, error) {
+	if s.token == nil || s.token.RefreshToken == "" {
+		return nil, errors.New("the OAuth token cannot be refreshed, restart the server to sign in again")
+	}
+
+	token, err := s.config.TokenSource(s.ctx, &oauth2.Token{RefreshToken: s.token.RefreshToken}).Token()
+	if err != nil {
+		return nil, fmt.Errorf("could not refresh the OAuth token: %w", err)
+	}
+	s.token = token
+	if err := s.store.save(token); err != nil {
+		logger.Warn("Could not save OAuth token", "file", s.store.path, "error", err.Error())
+	}
+	logger.Info("Refreshed OAuth token", "expiry", token.Expiry)
+	return token, nil
+}
+
+/* deviceLogin signs in through the device authorization grant, telling the user where to enter the code */
+func deviceLogin(ctx context.Context, config *oauth2.Config, out io.Writer) (*oauth2.Token, error) {
+	auth, err := config.DeviceAuth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not start the OAuth sign-in: %w", err)
+	}
+
+	verificationUri := auth.VerificationURI
+	if auth.VerificationURIComplete != "" {
+		verificationUri = auth.VerificationURIComplete
+	}
+	/* The plugin looks for this line in the output of the server */
+	fmt.Fprintf(out, "Gitlab sign-in: open %s and enter the code %s\n", verificationUri, auth.UserCode)
+
+	token, err := config.DeviceAccessToken(ctx, auth)
+	if err != nil {
+		return nil, fmt.Errorf("could not complete the OAuth sign-in: %w", err)
+	}
+	return token, nil
+}
+
+/*
+setupOAuth prepares the token source of the client. It uses the saved token, refreshing it if it expired, and
+signs in through the device authorization grant when there is no saved token or it cannot be refreshed.
+*/
+func setupOAuth(ctx context.Context, out io.Writer) (*oauthTokenSource, error) {
+	gitlabUrl := strings.TrimSuffix(pluginOptions.GitlabUrl, "/")
+	tr, err := newTransport()
+	if err != nil {
+		return nil, err
+	}
+	store, err := newOAuthStore(gitlabUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	source := &oauthTokenSource{
+		config: newOAuthConfig(gitlabUrl),
+		ctx:    context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: tr}),
+		store:  store,
+	}
+
+	token, err := store.load()
+	if err == nil {
+		source.token = token
+		if _, err = source.Token(); err == nil {
+			return source, nil
+		}
+		logger.Warn("Signing in again with OAuth", "error", err.Error())
+	} else if !errors.Is(err, os.ErrNotExist) {
+		logger.Warn("Signing in again with OAuth", "error", err.Error())
+	}
+
+	token, err = deviceLogin(source.ctx, source.config, out)
+	if err != nil {
+		return nil, err
+	}
+	source.token = token
+	if err := store.save(token); err != nil {
+		logger.Warn("Could not save OAuth token", "file", store.path, "error", err.Error())
+	}
+	return source, nil
+}
+
+/* oauthTransport refreshes the OAuth token and tries once more when Gitlab turns a token down in the middle of a session */
+type oauthTransport struct {
+	next   http.RoundTripper
+	source *oauthTokenSource
+}
+
+func (t oauthTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return t.next.RoundTrip(r)
+	}
+
+	/* The body is read by the first attempt, so it is kept for the second one */
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		r.Body.Close() // nolint
+		if err != nil {
+			return nil, err
+		}
+		r = r.Clone(r.Context())
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	res, err := t.next.RoundTrip(r)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+
+	token, err := t.source.rejected(accessToken)
+	if err != nil {
+		logger.Warn("Gitlab turned down the OAuth token", "request_id", requestId(r.Context()), "error", err.Error())
+		return res, nil
+	}
+	res.Body.Close() // nolint
+
+	retry := r.Clone(r.Context())
+	retry.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	if body != nil {
+		retry.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return t.next.RoundTrip(retry)
+}
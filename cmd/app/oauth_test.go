@@ -0,0 +1,228 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+/* fakeOAuthServer is a Gitlab instance that only knows the OAuth endpoints and the current user */
+type fakeOAuthServer struct {
+	*httptest.Server
+	mu           sync.Mutex
+	issued       int
+	access       string
+	refresh      string
+	deviceLogins int
+	refreshes    int
+}
+
+func newFakeOAuthServer(t *testing.T) *fakeOAuthServer {
+	f := &fakeOAuthServer{access: "access-0", refresh: "refresh-0"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/authorize_device", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.deviceLogins++
+		f.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"device_code":      "device-code",
+			"user_code":        "ABCD-EFGH",
+			"verification_uri": f.URL + "/oauth/device",
+			"expires_in":       60,
+			"interval":         1,
+		})
+	})
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		_ = r.ParseForm()
+		switch r.PostForm.Get("grant_type") {
+		case "urn:ietf:params:oauth:grant-type:device_code":
+			if r.PostForm.Get("device_code") != "device-code" {
+				f.fail(w, "invalid_grant")
+				return
+			}
+		case "refresh_token":
+			if r.PostForm.Get("refresh_token") != f.refresh {
+				f.fail(w, "invalid_grant")
+				return
+			}
+			f.refreshes++
+		default:
+			f.fail(w, "unsupported_grant_type")
+			return
+		}
+		f.issued++
+		f.access = fmt.Sprintf("access-%d", f.issued)
+		f.refresh = fmt.Sprintf("refresh-%d", f.issued)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  f.access,
+			"refresh_token": f.refresh,
+			"token_type":    "Bearer",
+			"expires_in":    7200,
+		})
+	})
+	mux.HandleFunc("/api/v4/user", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") != "Bearer "+f.access {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message":"401 Unauthorized"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":1,"username":"someone"}`))
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeOAuthServer) fail(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = fmt.Fprintf(w, `{"error":%q}`, code)
+}
+
+/* revoke makes Gitlab turn down the current access token, as when it is revoked before it expires */
+func (f *fakeOAuthServer) revoke() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.access = "revoked"
+}
+
+func useOAuth(t *testing.T, f *fakeOAuthServer) string {
+	path := filepath.Join(t.TempDir(), "oauth", "token.json")
+	pluginOptions = PluginOptions{GitlabUrl: f.URL}
+	pluginOptions.Auth.OAuth.ClientId = "some-application"
+	pluginOptions.Auth.OAuth.TokenPath = path
+	t.Cleanup(func() {
+		pluginOptions = PluginOptions{}
+		oauth = nil
+	})
+	return path
+}
+
+func TestSetupOAuth(t *testing.T) {
+	t.Run("Signs in with the device flow and saves the token for the user alone", func(t *testing.T) {
+		f := newFakeOAuthServer(t)
+		path := useOAuth(t, f)
+
+		var out strings.Builder
+		source, err := setupOAuth(context.Background(), &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "Gitlab sign-in: open "+f.URL+"/oauth/device and enter the code ABCD-EFGH") {
+			t.Errorf("Expected the sign-in instructions, got %q", out.String())
+		}
+		token, _ := source.Token()
+		assert(t, token.AccessToken, "access-1")
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, info.Mode().Perm(), os.FileMode(0600))
+		saved, _ := source.store.load()
+		assert(t, saved.RefreshToken, "refresh-1")
+	})
+	t.Run("Refreshes an expired saved token instead of signing in again", func(t *testing.T) {
+		f := newFakeOAuthServer(t)
+		path := useOAuth(t, f)
+		if err := (oauthStore{path}).save(&oauth2.Token{AccessToken: "access-0", RefreshToken: "refresh-0", Expiry: time.Now().Add(-time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+
+		source, err := setupOAuth(context.Background(), &strings.Builder{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, _ := source.Token()
+		assert(t, token.AccessToken, "access-1")
+		assert(t, f.deviceLogins, 0)
+		saved, _ := source.store.load()
+		assert(t, saved.RefreshToken, "refresh-1")
+	})
+	t.Run("Signs in again when the refresh token was revoked", func(t *testing.T) {
+		f := newFakeOAuthServer(t)
+		path := useOAuth(t, f)
+		if err := (oauthStore{path}).save(&oauth2.Token{AccessToken: "access-0", RefreshToken: "revoked", Expiry: time.Now().Add(-time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+
+		source, err := setupOAuth(context.Background(), &strings.Builder{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, _ := source.Token()
+		assert(t, token.AccessToken, "access-1")
+		assert(t, f.deviceLogins, 1)
+	})
+}
+
+func TestOAuthTransport(t *testing.T) {
+	t.Run("Refreshes the token and tries again when Gitlab turns it down", func(t *testing.T) {
+		f := newFakeOAuthServer(t)
+		path := useOAuth(t, f)
+		if err := (oauthStore{path}).save(&oauth2.Token{AccessToken: "access-0", RefreshToken: "refresh-0", Expiry: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+		source, err := setupOAuth(context.Background(), &strings.Builder{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		oauth = source
+
+		client, err := NewClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.revoke()
+
+		user, _, err := client.CurrentUser()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, user.Username, "someone")
+		assert(t, f.refreshes, 1)
+		saved, _ := source.store.load()
+		assert(t, saved.AccessToken, "access-1")
+	})
+	t.Run("Refreshes once for concurrent calls turned down with the same token", func(t *testing.T) {
+		f := newFakeOAuthServer(t)
+		path := useOAuth(t, f)
+		if err := (oauthStore{path}).save(&oauth2.Token{AccessToken: "access-0", RefreshToken: "refresh-0", Expiry: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+		source, err := setupOAuth(context.Background(), &strings.Builder{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.revoke()
+
+		var wg sync.WaitGroup
+		for range 5 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := source.rejected("access-0"); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+		assert(t, f.refreshes, 1)
+	})
+}
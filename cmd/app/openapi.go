@@ -0,0 +1,450 @@
+package app
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* apiMux is the router's ServeMux. It also keeps every route registered with middleware, to describe the API. */
+type apiMux struct {
+	*http.ServeMux
+	routes map[string]route
+}
+
+func newApiMux() *apiMux {
+	return &apiMux{ServeMux: http.NewServeMux(), routes: map[string]route{}}
+}
+
+func (m *apiMux) Handle(pattern string, h http.Handler) {
+	if r, ok := h.(route); ok {
+		m.routes[pattern] = r
+	}
+	m.ServeMux.Handle(pattern, h)
+}
+
+type OpenApiDocument struct {
+	OpenApi    string                                 `json:"openapi"`
+	Info       OpenApiInfo                            `json:"info"`
+	Paths      map[string]map[string]OpenApiOperation `json:"paths"`
+	Components OpenApiComponents                      `json:"components"`
+	Security   []map[string][]string                  `json:"security"`
+}
+
+type OpenApiInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+type OpenApiOperation struct {
+	OperationId string                     `json:"operationId"`
+	Description string                     `json:"description,omitempty"`
+	Parameters  []OpenApiParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenApiRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenApiResponse `json:"responses"`
+	Security    *[]map[string][]string     `json:"security,omitempty"`
+}
+
+type OpenApiParameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type OpenApiRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenApiMediaType `json:"content"`
+}
+
+type OpenApiResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenApiMediaType `json:"content,omitempty"`
+}
+
+type OpenApiMediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type OpenApiComponents struct {
+	Schemas         map[string]*Schema               `json:"schemas"`
+	SecuritySchemes map[string]OpenApiSecurityScheme `json:"securitySchemes"`
+}
+
+type OpenApiSecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+}
+
+/* Schema is the subset of JSON Schema that the payloads and their validator tags translate to */
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	MinProperties        *int               `json:"minProperties,omitempty"`
+	MaxProperties        *int               `json:"maxProperties,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum,omitempty"`
+}
+
+type openApiService struct {
+	mux *apiMux
+}
+
+func (a openApiService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodGet: OpenApiDocument{}}
+}
+
+/* openApiHandler describes every route that declares its methods, along with the payloads each method accepts */
+func (a openApiService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(newOpenApiDocument(a.mux.routes))
+	if err != nil {
+		handleError(w, err, "Could not encode response", http.StatusInternalServerError)
+	}
+}
+
+func newOpenApiDocument(routes map[string]route) OpenApiDocument {
+	doc := OpenApiDocument{
+		OpenApi: "3.1.0",
+		Info: OpenApiInfo{
+			Title:   "gitlab.nvim",
+			Version: version,
+			Description: "The API of the gitlab.nvim server. Routes under /mr/ act on the merge request for the current branch, " +
+				"or on another one when addressed as /mr/{iid}/... or with an iid query parameter.",
+		},
+		Paths: map[string]map[string]OpenApiOperation{},
+		Components: OpenApiComponents{
+			Schemas:         map[string]*Schema{},
+			SecuritySchemes: map[string]OpenApiSecurityScheme{"secret": {Type: "http", Scheme: "bearer"}},
+		},
+		Security: []map[string][]string{{"secret": {}}},
+	}
+
+	errorSchema := schemaFor(reflect.TypeOf(ErrorResponse{}), doc.Components.Schemas)
+
+	for pattern, r := range routes {
+		if len(r.methods) == 0 {
+			continue
+		}
+
+		operations := map[string]OpenApiOperation{}
+		addressed := map[string]OpenApiOperation{}
+		for _, method := range r.methods {
+			op := OpenApiOperation{
+				OperationId: strings.ToLower(method) + operationName(pattern),
+				Responses: map[string]OpenApiResponse{
+					"200":     successResponse(r.responses[method], doc.Components.Schemas),
+					"default": {Description: "Error", Content: map[string]OpenApiMediaType{"application/json": {Schema: errorSchema}}},
+				},
+			}
+
+			/* Public routes are served without the secret */
+			if r.public {
+				op.Security = &[]map[string][]string{}
+			}
+
+			/* Patterns ending in a slash also match longer paths, where handlers read an ID from the rest of the path */
+			if strings.HasSuffix(pattern, "/") {
+				op.Description = "Also serves " + pattern + "{id}"
+			}
+
+			if constructor, ok := r.payloads[method]; ok {
+				op.RequestBody = &OpenApiRequestBody{
+					Required: true,
+					Content: map[string]OpenApiMediaType{
+						"application/json": {Schema: schemaFor(reflect.TypeOf(constructor()).Elem(), doc.Components.Schemas)},
+					},
+				}
+			}
+
+			/* Merge request routes are also served as /mr/{iid}/..., for a merge request other than the current one */
+			if rest, ok := strings.CutPrefix(pattern, "/mr/"); ok {
+				byPath := op
+				byPath.OperationId = strings.ToLower(method) + "MrByIid" + operationName(rest)
+				if byPath.Description != "" {
+					byPath.Description = "Also serves /mr/{iid}/" + rest + "{id}"
+				}
+				byPath.Parameters = []OpenApiParameter{{
+					Name:        "iid",
+					In:          "path",
+					Description: "The merge request to act on",
+					Required:    true,
+					Schema:      &Schema{Type: "integer"},
+				}}
+				addressed[strings.ToLower(method)] = byPath
+
+				op.Parameters = append(op.Parameters, OpenApiParameter{
+					Name:        "iid",
+					In:          "query",
+					Description: "The merge request to act on, instead of the one for the current branch",
+					Schema:      &Schema{Type: "integer"},
+				})
+			}
+
+			operations[strings.ToLower(method)] = op
+		}
+		doc.Paths[pattern] = operations
+		if len(addressed) > 0 {
+			doc.Paths["/mr/{iid}/"+strings.TrimPrefix(pattern, "/mr/")] = addressed
+		}
+	}
+
+	return doc
+}
+
+/* successResponse describes the body a route answers with, which is a SuccessResponse unless the service says otherwise */
+func successResponse(body any, components map[string]*Schema) OpenApiResponse {
+	switch body := body.(type) {
+	case nil:
+		return OpenApiResponse{Description: "Success", Content: map[string]OpenApiMediaType{"application/json": {Schema: schemaFor(reflect.TypeOf(SuccessResponse{}), components)}}}
+	case plainText:
+		return OpenApiResponse{Description: "Success", Content: map[string]OpenApiMediaType{"text/plain": {Schema: &Schema{Type: "string"}}}}
+	case eventStream:
+		return OpenApiResponse{
+			Description: "A stream of server-sent events, each with a JSON object as its data",
+			Content:     map[string]OpenApiMediaType{"text/event-stream": {Schema: schemaFor(reflect.TypeOf(body.event), components)}},
+		}
+	default:
+		return OpenApiResponse{Description: "Success", Content: map[string]OpenApiMediaType{"application/json": {Schema: schemaFor(reflect.TypeOf(body), components)}}}
+	}
+}
+
+var pathSeparators = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+/* operationName turns a path such as /mr/draft_notes/publish into MrDraftNotesPublish */
+func operationName(path string) string {
+	var name strings.Builder
+	for _, part := range pathSeparators.Split(path, -1) {
+		if part != "" {
+			name.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return name.String()
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+/*
+schemaFor describes how a Go type is encoded as JSON. Named structs are added to the components once and
+referenced from then on, which also handles types that refer to themselves.
+*/
+func schemaFor(t reflect.Type, components map[string]*Schema) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		return &Schema{}
+	case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		return &Schema{} // Encodes itself, so its shape cannot be known from the type
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: schemaFor(t.Elem(), components)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaFor(t.Elem(), components)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, components)
+		}
+		name := componentName(t)
+		ref := &Schema{Ref: "#/components/schemas/" + name}
+		if _, exists := components[name]; !exists {
+			components[name] = &Schema{} // Reserve the name before recursing, for self-referencing types
+			components[name] = structSchema(t, components)
+		}
+		return ref
+	default:
+		return &Schema{}
+	}
+}
+
+/* componentName names a struct after its package and type, so that ours and the GitLab client's never collide */
+func componentName(t reflect.Type) string {
+	pkg := t.PkgPath()
+	if pkg == reflect.TypeOf(route{}).PkgPath() {
+		return t.Name()
+	}
+	return pkg[strings.LastIndex(pkg, "/")+1:] + "." + t.Name()
+}
+
+func structSchema(t reflect.Type, components map[string]*Schema) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	addFields(schema, t, components)
+	sort.Strings(schema.Required)
+	return schema
+}
+
+/* addFields adds the fields of a struct to a schema the way encoding/json encodes them, flattening embedded structs */
+func addFields(schema *Schema, t reflect.Type, components map[string]*Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				addFields(schema, embedded, components)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := schemaFor(field.Type, components)
+		if applyValidation(property, field.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+}
+
+/*
+applyValidation translates validator tags into schema constraints and reports whether the field is required.
+Tags after "dive" apply to the items of a slice or map. Tags without a JSON Schema equivalent are skipped.
+*/
+func applyValidation(target *Schema, tag string) (required bool) {
+	if tag == "" || tag == "-" {
+		return false
+	}
+
+	for _, rule := range strings.Split(tag, ",") {
+		if rule == "dive" {
+			if target.Items != nil {
+				rest := tag[strings.Index(tag, "dive")+len("dive"):]
+				applyValidation(target.Items, strings.TrimPrefix(rest, ","))
+			}
+			break
+		}
+
+		key, value, _ := strings.Cut(rule, "=")
+		switch key {
+		case "required":
+			required = true
+		case "oneof":
+			target.Enum = strings.Fields(value)
+		case "startswith":
+			target.Pattern = "^" + regexp.QuoteMeta(value)
+		case "email":
+			target.Format = "email"
+		case "url":
+			target.Format = "uri"
+		case "min", "gte":
+			setBound(target, value, true, false)
+		case "max", "lte":
+			setBound(target, value, false, false)
+		case "gt":
+			setBound(target, value, true, true)
+		case "lt":
+			setBound(target, value, false, true)
+		case "len":
+			setBound(target, value, true, false)
+			setBound(target, value, false, false)
+		}
+	}
+
+	return required
+}
+
+/* setBound applies a min or max rule, which the validator checks against the length of strings and collections */
+func setBound(s *Schema, value string, lower bool, exclusive bool) {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return
+	}
+	length := int(n)
+	if exclusive {
+		length = int(n) + 1
+		if !lower {
+			length = int(n) - 1
+		}
+	}
+
+	switch s.Type {
+	case "string":
+		if lower {
+			s.MinLength = &length
+		} else {
+			s.MaxLength = &length
+		}
+	case "array":
+		if lower {
+			s.MinItems = &length
+		} else {
+			s.MaxItems = &length
+		}
+	case "object":
+		if lower {
+			s.MinProperties = &length
+		} else {
+			s.MaxProperties = &length
+		}
+	case "integer", "number":
+		switch {
+		case lower && exclusive:
+			s.ExclusiveMinimum = &n
+		case lower:
+			s.Minimum = &n
+		case exclusive:
+			s.ExclusiveMaximum = &n
+		default:
+			s.Maximum = &n
+		}
+	}
+}
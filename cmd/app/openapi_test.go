@@ -0,0 +1,112 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func getOpenApiDocument(t *testing.T) OpenApiDocument {
+	t.Helper()
+	router := CreateRouter(&Client{}, &ProjectInfo{}, &shutdownService{}, func(a *data) error { a.secret = "s3cret"; return nil })
+	request := makeRequest(t, http.MethodGet, "/openapi.json", nil)
+	request.Header.Set("Authorization", "Bearer s3cret")
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, request)
+	assert(t, res.Code, http.StatusOK)
+
+	var doc OpenApiDocument
+	err := json.Unmarshal(res.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestOpenApiHandler(t *testing.T) {
+	doc := getOpenApiDocument(t)
+	schemas := doc.Components.Schemas
+
+	t.Run("Describes the methods of every route", func(t *testing.T) {
+		comment := doc.Paths["/mr/comment"]
+		assert(t, len(comment), 3)
+		for _, method := range []string{"post", "delete", "patch"} {
+			if _, ok := comment[method]; !ok {
+				t.Errorf("Expected /mr/comment to accept %s", method)
+			}
+		}
+		if _, ok := doc.Paths["/mr/info"]["get"]; !ok {
+			t.Error("Expected /mr/info to accept get")
+		}
+		if _, ok := doc.Paths["/openapi.json"]["get"]; !ok {
+			t.Error("Expected the document to describe itself")
+		}
+	})
+	t.Run("Describes the payloads and their validation", func(t *testing.T) {
+		body := doc.Paths["/mr/comment"]["post"].RequestBody
+		if body == nil {
+			t.Fatal("Expected a request body")
+		}
+		assert(t, body.Content["application/json"].Schema.Ref, "#/components/schemas/PostCommentRequest")
+
+		comment := schemas["PostCommentRequest"]
+		assert(t, comment.Properties["comment"].Type, "string")
+		assert(t, comment.Properties["file_name"].Type, "string") // From the embedded PositionData
+		assert(t, len(comment.Required), 1)
+		assert(t, comment.Required[0], "comment")
+
+		batch := schemas["BatchRequest"]
+		assert(t, *batch.Properties["requests"].MinItems, 1)
+		assert(t, batch.Properties["requests"].Items.Ref, "#/components/schemas/BatchSubRequest")
+		assert(t, schemas["BatchSubRequest"].Properties["path"].Pattern, "^/")
+
+		session := schemas["SessionUpdateRequest"]
+		assert(t, *session.Properties["branch"].MinLength, 1)
+		assert(t, *session.Properties["chosen_mr_iid"].Minimum, float64(0))
+	})
+	t.Run("Documents merge request addressing on merge request routes", func(t *testing.T) {
+		params := doc.Paths["/mr/info"]["get"].Parameters
+		assert(t, len(params), 1)
+		assert(t, params[0].Name, "iid")
+		assert(t, len(doc.Paths["/pipeline"]["get"].Parameters), 0)
+	})
+	t.Run("Describes the response of each method", func(t *testing.T) {
+		info := doc.Paths["/mr/info"]["get"].Responses["200"].Content["application/json"]
+		assert(t, info.Schema.Ref, "#/components/schemas/InfoResponse")
+		deleted := doc.Paths["/mr/comment"]["delete"].Responses["200"].Content["application/json"]
+		assert(t, deleted.Schema.Ref, "#/components/schemas/SuccessResponse")
+		posted := doc.Paths["/mr/comment"]["post"].Responses["200"].Content["application/json"]
+		assert(t, posted.Schema.Ref, "#/components/schemas/CommentResponse")
+
+		events := doc.Paths["/events"]["get"].Responses["200"].Content
+		assert(t, len(events), 1)
+		assert(t, events["text/event-stream"].Schema.Ref, "#/components/schemas/MergeRequestEvent")
+	})
+	t.Run("Describes merge request routes addressed by path", func(t *testing.T) {
+		op, ok := doc.Paths["/mr/{iid}/info"]["get"]
+		if !ok {
+			t.Fatal("Expected /mr/{iid}/info")
+		}
+		assert(t, op.OperationId, "getMrByIidInfo")
+		assert(t, len(op.Parameters), 1)
+		assert(t, op.Parameters[0].In, "path")
+		assert(t, op.Parameters[0].Required, true)
+		assert(t, op.Responses["200"].Content["application/json"].Schema.Ref, "#/components/schemas/InfoResponse")
+		assert(t, doc.Paths["/mr/{iid}/draft_notes/"]["get"].Description, "Also serves /mr/{iid}/draft_notes/{id}")
+		if _, ok := doc.Paths["/mr/{iid}/comment"]["post"]; !ok {
+			t.Error("Expected /mr/{iid}/comment to accept post")
+		}
+	})
+	t.Run("Describes the ping and version routes", func(t *testing.T) {
+		ping := doc.Paths["/ping"]["get"]
+		assert(t, ping.Responses["200"].Content["text/plain"].Schema.Type, "string")
+		if ping.Security == nil || len(*ping.Security) != 0 {
+			t.Error("Expected /ping to be served without the secret")
+		}
+
+		version := doc.Paths["/version"]["get"]
+		assert(t, version.Responses["200"].Content["application/json"].Schema.Ref, "#/components/schemas/VersionResponse")
+		assert(t, version.Security == nil, true)
+	})
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -41,6 +42,13 @@ type pipelineService struct {
 	gitService git.GitManager
 }
 
+func (a pipelineService) describe(r *route) {
+	r.responses = methodToResponse{
+		http.MethodGet:  GetPipelineAndJobsResponse{},
+		http.MethodPost: RetriggerPipelineResponse{},
+	}
+}
+
 /*
 pipelineHandler fetches information about the current pipeline, and retriggers a pipeline run. For more detailed information
 about a given job in a pipeline, see the jobHandler function
@@ -55,14 +63,14 @@ func (a pipelineService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 /* Gets the latest pipeline for a given commit, returns an error if there is no pipeline */
-func (a pipelineService) GetLastPipeline(commit string) (*gitlab.PipelineInfo, error) {
+func (a pipelineService) GetLastPipeline(ctx context.Context, projectId string, commit string) (*gitlab.PipelineInfo, error) {
 
 	l := &gitlab.ListProjectPipelinesOptions{
 		SHA:  gitlab.Ptr(commit),
 		Sort: gitlab.Ptr("desc"),
 	}
 
-	pipes, res, err := a.client.ListProjectPipelines(a.projectInfo.ProjectId, l)
+	pipes, res, err := a.client.ListProjectPipelines(projectId, l, gitlab.WithContext(ctx))
 
 	if err != nil {
 		return nil, err
@@ -83,26 +91,27 @@ func (a pipelineService) GetLastPipeline(commit string) (*gitlab.PipelineInfo, e
 func (a pipelineService) GetPipelineAndJobs(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	commit, err := a.gitService.GetLatestCommitOnRemote(pluginOptions.ConnectionSettings.Remote, a.gitInfo.BranchName)
+	branch := a.state.BranchName()
+	commit, err := a.gitService.GetLatestCommitOnRemote(a.state.Remote(), branch)
 
 	if err != nil {
 		handleError(w, err, "Error getting commit on remote branch", http.StatusInternalServerError)
 		return
 	}
 
-	pipeline, err := a.GetLastPipeline(commit)
+	pipeline, err := a.GetLastPipeline(r.Context(), a.projectId(r), commit)
 
 	if err != nil {
-		handleError(w, err, fmt.Sprintf("Failed to get latest pipeline for %s branch", a.gitInfo.BranchName), http.StatusInternalServerError)
+		handleError(w, err, fmt.Sprintf("Failed to get latest pipeline for %s branch", branch), http.StatusInternalServerError)
 		return
 	}
 
 	if pipeline == nil {
-		handleError(w, GenericError{r.URL.Path}, fmt.Sprintf("No pipeline found for %s branch", a.gitInfo.BranchName), http.StatusInternalServerError)
+		handleError(w, GenericError{r.URL.Path}, fmt.Sprintf("No pipeline found for %s branch", branch), http.StatusInternalServerError)
 		return
 	}
 
-	jobs, res, err := a.client.ListPipelineJobs(a.projectInfo.ProjectId, pipeline.ID, &gitlab.ListJobsOptions{})
+	jobs, res, err := a.client.ListPipelineJobs(a.projectId(r), pipeline.ID, &gitlab.ListJobsOptions{}, gitlab.WithContext(r.Context()))
 	if err != nil {
 		handleError(w, err, "Could not get pipeline jobs", http.StatusInternalServerError)
 		return
@@ -120,7 +129,7 @@ func (a pipelineService) GetPipelineAndJobs(w http.ResponseWriter, r *http.Reque
 		Name:           "root",
 	})
 
-	bridges, res, err := a.client.ListPipelineBridges(a.projectInfo.ProjectId, pipeline.ID, &gitlab.ListJobsOptions{})
+	bridges, res, err := a.client.ListPipelineBridges(a.projectId(r), pipeline.ID, &gitlab.ListJobsOptions{}, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not get pipeline trigger jobs", http.StatusInternalServerError)
@@ -131,24 +140,38 @@ func (a pipelineService) GetPipelineAndJobs(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	triggered := []*gitlab.Bridge{}
 	for _, bridge := range bridges {
-		if bridge.DownstreamPipeline == nil {
-			continue
+		if bridge.DownstreamPipeline != nil {
+			triggered = append(triggered, bridge)
 		}
+	}
+
+	type bridgeJobs struct {
+		jobs   []*gitlab.Job
+		status int
+		err    error
+	}
 
-		pipelineIdInBridge := bridge.DownstreamPipeline.ID
-		bridgePipelineJobs, res, err := a.client.ListPipelineJobs(bridge.DownstreamPipeline.ProjectID, pipelineIdInBridge, &gitlab.ListJobsOptions{})
+	/* The first failure in the order of the bridges is reported, so the errors are collected rather than ending the fan-out */
+	results, _ := fanOut(triggered, func(bridge *gitlab.Bridge) (bridgeJobs, error) {
+		jobs, res, err := a.client.ListPipelineJobs(bridge.DownstreamPipeline.ProjectID, bridge.DownstreamPipeline.ID, &gitlab.ListJobsOptions{}, gitlab.WithContext(r.Context()))
 		if err != nil {
-			handleError(w, err, "Could not get jobs for a pipeline from a trigger job", http.StatusInternalServerError)
-			return
+			return bridgeJobs{err: err, status: http.StatusInternalServerError}, nil
 		}
 		if res.StatusCode >= 300 {
-			handleError(w, GenericError{r.URL.Path}, "Could not get jobs for a pipeline from a trigger job", res.StatusCode)
-			return
+			return bridgeJobs{err: GenericError{r.URL.Path}, status: res.StatusCode}, nil
 		}
+		return bridgeJobs{jobs: jobs}, nil
+	})
 
+	for i, bridge := range triggered {
+		if results[i].err != nil {
+			handleError(w, results[i].err, "Could not get jobs for a pipeline from a trigger job", results[i].status)
+			return
+		}
 		pipelines = append(pipelines, PipelineWithJobs{
-			Jobs:           bridgePipelineJobs,
+			Jobs:           results[i].jobs,
 			LatestPipeline: bridge.DownstreamPipeline,
 			Name:           bridge.Name,
 		})
@@ -177,7 +200,7 @@ func (a pipelineService) RetriggerPipeline(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	pipeline, res, err := a.client.RetryPipelineBuild(a.projectInfo.ProjectId, idInt)
+	pipeline, res, err := a.client.RetryPipelineBuild(a.projectId(r), idInt, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not retrigger pipeline", http.StatusInternalServerError)
@@ -0,0 +1,21 @@
+//go:build windows
+
+package app
+
+import "syscall"
+
+/* STILL_ACTIVE is the exit code Windows reports for a process that is still running */
+const stillActive = 259
+
+/* processAlive reports whether a process with the given PID exists and has not exited */
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h) // nolint
+
+	var code uint32
+	err = syscall.GetExitCodeProcess(h, &code)
+	return err == nil && code == stillActive
+}
@@ -0,0 +1,261 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/* httpProxyStandIn answers every request itself, recording what a proxy would have been asked */
+type httpProxyStandIn struct {
+	*httptest.Server
+	mu            sync.Mutex
+	url           string
+	authorization string
+}
+
+func newHttpProxyStandIn(t *testing.T) *httpProxyStandIn {
+	p := &httpProxyStandIn{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.url = r.URL.String()
+		p.authorization = r.Header.Get("Proxy-Authorization")
+		p.mu.Unlock()
+		_, _ = w.Write([]byte("proxied"))
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+/*
+socksStandIn is a SOCKS5 proxy that asks for a username and password and connects every destination to target,
+recording the destination and credentials it was given.
+*/
+type socksStandIn struct {
+	listener    net.Listener
+	target      string
+	mu          sync.Mutex
+	destination string
+	credentials string
+}
+
+func newSocksStandIn(t *testing.T, target string) *socksStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socksStandIn{listener: listener, target: target}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *socksStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil
+		}
+		return b
+	}
+
+	/* Greeting, answered with the username and password method */
+	greeting := read(2)
+	if greeting == nil || read(int(greeting[1])) == nil {
+		return
+	}
+	_, _ = conn.Write([]byte{5, 2})
+
+	/* Username and password */
+	header := read(2)
+	if header == nil {
+		return
+	}
+	username := read(int(header[1]))
+	passwordLength := read(1)
+	if passwordLength == nil {
+		return
+	}
+	password := read(int(passwordLength[0]))
+	_, _ = conn.Write([]byte{1, 0})
+
+	/* Connect request, with the destination as a domain name, IPv4 or IPv6 address */
+	request := read(4)
+	if request == nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 3:
+		length := read(1)
+		host = string(read(int(length[0])))
+	case 1:
+		host = net.IP(read(4)).String()
+	case 4:
+		host = net.IP(read(16)).String()
+	}
+	port := binary.BigEndian.Uint16(read(2))
+
+	s.mu.Lock()
+	s.destination = fmt.Sprintf("%s:%d", host, port)
+	s.credentials = string(username) + ":" + string(password)
+	s.mu.Unlock()
+
+	upstream, err := net.Dial("tcp", s.target)
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go func() { _, _ = io.Copy(upstream, conn) }()
+	_, _ = io.Copy(conn, upstream)
+}
+
+func proxyFor(t *testing.T, settings TransportSettings, url string) string {
+	t.Helper()
+	proxy, err := newProxyFunc(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := proxy(httptest.NewRequest(http.MethodGet, url, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u == nil {
+		return "direct"
+	}
+	return u.String()
+}
+
+func clearProxyEnv(t *testing.T) {
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy", "REQUEST_METHOD"} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestProxy(t *testing.T) {
+	t.Run("Sends the credentials in the proxy URL to an HTTP proxy", func(t *testing.T) {
+		clearProxyEnv(t)
+		proxy := newHttpProxyStandIn(t)
+		proxyUrl := strings.Replace(proxy.URL, "http://", "http://someone:p%40ss@", 1)
+
+		body, err := getWithSettings(t, TransportSettings{Proxy: proxyUrl}, "http://gitlab.example.com/api/v4/version")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, body, "proxied")
+		assert(t, proxy.url, "http://gitlab.example.com/api/v4/version")
+		assert(t, proxy.authorization, "Basic "+base64.StdEncoding.EncodeToString([]byte("someone:p@ss")))
+	})
+	t.Run("Uses the proxy of the environment when none is configured", func(t *testing.T) {
+		clearProxyEnv(t)
+		proxy := newHttpProxyStandIn(t)
+		t.Setenv("HTTP_PROXY", proxy.URL)
+
+		body, err := getWithSettings(t, TransportSettings{}, "http://gitlab.example.com/api/v4/version")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, body, "proxied")
+		assert(t, proxy.url, "http://gitlab.example.com/api/v4/version")
+	})
+	t.Run("Connects through a SOCKS5 proxy with the credentials in its URL", func(t *testing.T) {
+		clearProxyEnv(t)
+		gitlab := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("from " + r.Host))
+		}))
+		t.Cleanup(gitlab.Close)
+		socks := newSocksStandIn(t, gitlab.Listener.Addr().String())
+
+		body, err := getWithSettings(t, TransportSettings{Proxy: "socks5://someone:secret@" + socks.listener.Addr().String()}, "http://gitlab.example.com/api/v4/version")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, body, "from gitlab.example.com")
+		assert(t, socks.destination, "gitlab.example.com:80")
+		assert(t, socks.credentials, "someone:secret")
+	})
+	t.Run("Reaches the hosts of NO_PROXY and of the bypass list directly", func(t *testing.T) {
+		clearProxyEnv(t)
+		t.Setenv("HTTPS_PROXY", "http://env-proxy.example.com:3128")
+		t.Setenv("NO_PROXY", "env-bypass.example.com")
+		settings := TransportSettings{NoProxy: []string{".internal.example.com", "10.0.0.0/8"}}
+
+		assert(t, proxyFor(t, settings, "https://gitlab.com/api/v4/user"), "http://env-proxy.example.com:3128")
+		assert(t, proxyFor(t, settings, "https://env-bypass.example.com/api/v4/user"), "direct")
+		assert(t, proxyFor(t, settings, "https://git.internal.example.com/api/v4/user"), "direct")
+		assert(t, proxyFor(t, settings, "https://10.1.2.3/api/v4/user"), "direct")
+	})
+	t.Run("Applies the bypass list to the configured proxy", func(t *testing.T) {
+		clearProxyEnv(t)
+		settings := TransportSettings{Proxy: "socks5://proxy.example.com:1080", NoProxy: []string{"git.example.com"}}
+
+		assert(t, proxyFor(t, settings, "https://gitlab.com/api/v4/user"), "socks5://proxy.example.com:1080")
+		assert(t, proxyFor(t, settings, "https://git.example.com/api/v4/user"), "direct")
+	})
+	t.Run("Sends localhost and loopback addresses through a configured proxy", func(t *testing.T) {
+		clearProxyEnv(t)
+		settings := TransportSettings{Proxy: "http://proxy.example.com:3128", NoProxy: []string{"127.0.0.2"}}
+
+		assert(t, proxyFor(t, settings, "http://localhost:8080/api/v4/user"), "http://proxy.example.com:3128")
+		assert(t, proxyFor(t, settings, "http://127.0.0.1:8080/api/v4/user"), "http://proxy.example.com:3128")
+		assert(t, proxyFor(t, settings, "http://[::1]:8080/api/v4/user"), "http://proxy.example.com:3128")
+		assert(t, proxyFor(t, settings, "http://127.0.0.2:8080/api/v4/user"), "direct")
+	})
+	t.Run("Reads the bypass list of a configured proxy like NO_PROXY", func(t *testing.T) {
+		clearProxyEnv(t)
+		settings := TransportSettings{
+			Proxy:   "http://proxy.example.com:3128",
+			NoProxy: []string{"example.com", ".internal.example.org", "10.0.0.0/8", "[::1]:8443", "git.example.net:8080"},
+		}
+		tests := []struct {
+			url   string
+			proxy string
+		}{
+			{"https://example.com/api/v4/user", "direct"},
+			{"https://git.example.com/api/v4/user", "direct"},
+			{"https://notexample.com/api/v4/user", "http://proxy.example.com:3128"},
+			{"https://git.internal.example.org/api/v4/user", "direct"},
+			{"https://internal.example.org/api/v4/user", "http://proxy.example.com:3128"},
+			{"https://10.1.2.3/api/v4/user", "direct"},
+			{"https://[::1]:8443/api/v4/user", "direct"},
+			{"https://[::1]/api/v4/user", "http://proxy.example.com:3128"},
+			{"http://git.example.net:8080/api/v4/user", "direct"},
+			{"http://git.example.net/api/v4/user", "http://proxy.example.com:3128"},
+		}
+		for _, tt := range tests {
+			assert(t, proxyFor(t, settings, tt.url), tt.proxy)
+		}
+	})
+	t.Run("Reaches localhost directly through the proxy of the environment", func(t *testing.T) {
+		clearProxyEnv(t)
+		t.Setenv("HTTP_PROXY", "http://env-proxy.example.com:3128")
+
+		assert(t, proxyFor(t, TransportSettings{}, "http://localhost:8080/api/v4/user"), "direct")
+	})
+	t.Run("Rejects proxies it cannot talk to", func(t *testing.T) {
+		_, err := newProxyFunc(TransportSettings{Proxy: "ftp://proxy.example.com"})
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		assert(t, err.Error(), `unsupported proxy scheme "ftp", expected one of http, https, socks5`)
+	})
+}
@@ -28,6 +28,10 @@ type replyService struct {
 	client ReplyManager
 }
 
+func (a replyService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPost: ReplyResponse{}}
+}
+
 /* replyHandler sends a reply to a note or comment */
 func (a replyService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	replyRequest := r.Context().Value(payload("payload")).(*ReplyRequest)
@@ -38,7 +42,7 @@ func (a replyService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		CreatedAt: &now,
 	}
 
-	note, res, err := a.client.AddMergeRequestDiscussionNote(a.projectInfo.ProjectId, a.projectInfo.MergeId, replyRequest.DiscussionId, &options)
+	note, res, err := a.client.AddMergeRequestDiscussionNote(a.projectId(r), a.mergeId(r), replyRequest.DiscussionId, &options, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not leave reply", http.StatusInternalServerError)
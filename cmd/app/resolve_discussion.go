@@ -17,6 +17,10 @@ type discussionsResolutionService struct {
 	client DiscussionResolver
 }
 
+func (a discussionsResolutionService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPut: SuccessResponse{}}
+}
+
 type DiscussionResolveRequest struct {
 	DiscussionID string `json:"discussion_id" validate:"required"`
 	Resolved     bool   `json:"resolved"`
@@ -27,10 +31,11 @@ func (a discussionsResolutionService) ServeHTTP(w http.ResponseWriter, r *http.R
 	payload := r.Context().Value(payload("payload")).(*DiscussionResolveRequest)
 
 	_, res, err := a.client.ResolveMergeRequestDiscussion(
-		a.projectInfo.ProjectId,
-		a.projectInfo.MergeId,
+		a.projectId(r),
+		a.mergeId(r),
 		payload.DiscussionID,
 		&gitlab.ResolveMergeRequestDiscussionOptions{Resolved: &payload.Resolved},
+		gitlab.WithContext(r.Context()),
 	)
 
 	friendlyName := "unresolve"
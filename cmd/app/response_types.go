@@ -5,14 +5,22 @@ import (
 )
 
 type ErrorResponse struct {
-	Message string `json:"message"`
-	Details string `json:"details"`
+	Message       string       `json:"message"`
+	Details       string       `json:"details"`
+	Code          ErrorCode    `json:"code"`
+	GitlabStatus  int          `json:"gitlab_status,omitempty"`
+	GitlabMessage string       `json:"gitlab_message,omitempty"`
+	Fields        []FieldError `json:"fields,omitempty"`
 }
 
 type SuccessResponse struct {
 	Message string `json:"message"`
 }
 
+type VersionResponse struct {
+	Version string `json:"version"`
+}
+
 type GenericError struct {
 	endpoint string
 }
@@ -0,0 +1,171 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+/* Used when the connection settings leave the retry settings out */
+const (
+	defaultMaxAttempts = 3
+	defaultMinBackoff  = 200 * time.Millisecond
+	defaultMaxBackoff  = 5 * time.Second
+)
+
+/* GitLab may ask us to wait longer than we would back off on our own, but not longer than this */
+const maxServerWait = time.Minute
+
+/* retryPolicy decides which failed calls to GitLab are tried again, and how long to wait before doing so */
+type retryPolicy struct {
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	now         func() time.Time
+}
+
+func newRetryPolicy() retryPolicy {
+	settings := pluginOptions.ConnectionSettings.Retry
+	policy := retryPolicy{
+		maxAttempts: defaultMaxAttempts,
+		minBackoff:  defaultMinBackoff,
+		maxBackoff:  defaultMaxBackoff,
+		now:         time.Now,
+	}
+	if settings.MaxAttempts > 0 {
+		policy.maxAttempts = settings.MaxAttempts
+	}
+	if settings.MinBackoffMs > 0 {
+		policy.minBackoff = time.Duration(settings.MinBackoffMs) * time.Millisecond
+	}
+	if settings.MaxBackoffMs > 0 {
+		policy.maxBackoff = time.Duration(settings.MaxBackoffMs) * time.Millisecond
+	}
+	policy.maxBackoff = max(policy.maxBackoff, policy.minBackoff)
+	return policy
+}
+
+/* clientOptions configures the GitLab client with the policy */
+func (p retryPolicy) clientOptions() []gitlab.ClientOptionFunc {
+	if p.maxAttempts <= 1 {
+		return []gitlab.ClientOptionFunc{gitlab.WithoutRetries()}
+	}
+	return []gitlab.ClientOptionFunc{
+		gitlab.WithCustomRetryMax(p.maxAttempts - 1),
+		gitlab.WithCustomRetryWaitMinMax(p.minBackoff, p.maxBackoff),
+		gitlab.WithCustomRetry(p.checkRetry),
+		gitlab.WithCustomBackoff(p.backoff),
+	}
+}
+
+func isIdempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+/*
+checkRetry retries reads when GitLab is briefly unavailable or the connection fails. Calls that change
+something, such as accepting a merge request, are only retried when GitLab could not have acted on them: when it
+turned them away with a 429, or when the connection was never established.
+*/
+func (p retryPolicy) checkRetry(ctx context.Context, res *http.Response, err error) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+
+	if err != nil {
+		var urlErr *url.Error
+		if !errors.As(err, &urlErr) {
+			return false, err
+		}
+		/* The client names the method of a failed request in the error, as in `Get "https://..."` */
+		if isIdempotent(strings.ToUpper(urlErr.Op)) {
+			return !isPermanent(err), nil
+		}
+		return neverSent(err), nil
+	}
+
+	switch res.StatusCode {
+	case http.StatusTooManyRequests:
+		return true, nil
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return isIdempotent(res.Request.Method), nil
+	}
+	return false, nil
+}
+
+/* isPermanent reports errors that would happen again, such as a certificate that is not trusted */
+func isPermanent(err error) bool {
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &certErr), errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr):
+		return true
+	case errors.As(err, &dnsErr):
+		return dnsErr.IsNotFound
+	}
+	return false
+}
+
+/* neverSent reports errors that happen before a request leaves the machine, such as a refused connection */
+func neverSent(err error) bool {
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr):
+		return !dnsErr.IsNotFound
+	case errors.As(err, &opErr):
+		return opErr.Op == "dial"
+	}
+	return false
+}
+
+/*
+backoff waits exponentially longer after every attempt, with jitter so that parallel calls do not retry in
+lockstep. When GitLab says how long to wait, through Retry-After or once RateLimit-Remaining reaches zero
+through RateLimit-Reset, that wait is used instead.
+*/
+func (p retryPolicy) backoff(minWait time.Duration, maxWait time.Duration, attempt int, res *http.Response) time.Duration {
+	if res != nil {
+		if wait, ok := p.serverWait(res.Header); ok {
+			return min(wait, maxServerWait)
+		}
+	}
+
+	wait := minWait << min(attempt, 30)
+	if wait <= 0 || wait > maxWait {
+		wait = maxWait
+	}
+	half := wait / 2
+	return half + time.Duration(rand.Int64N(int64(half)+1))
+}
+
+func (p retryPolicy) serverWait(header http.Header) (time.Duration, bool) {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return max(date.Sub(p.now()), 0), true
+		}
+	}
+
+	if header.Get("RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(header.Get("RateLimit-Reset"), 10, 64); err == nil {
+			return max(time.Unix(reset, 0).Sub(p.now()), 0), true
+		}
+	}
+
+	return 0, false
+}
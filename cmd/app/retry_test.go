@@ -0,0 +1,110 @@
+package app
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+/* newFlakyGitlab fails the first calls with the given status and counts all calls */
+func newFlakyGitlab(t *testing.T, failures int32, status int, header http.Header) (*gitlab.Client, *atomic.Int32) {
+	t.Helper()
+	calls := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			for name, values := range header {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(status)
+			return
+		}
+		_, _ = w.Write([]byte(`{"iid": 1}`))
+	}))
+	t.Cleanup(server.Close)
+
+	policy := retryPolicy{maxAttempts: 3, minBackoff: time.Millisecond, maxBackoff: 2 * time.Millisecond, now: time.Now}
+	options := append([]gitlab.ClientOptionFunc{gitlab.WithBaseURL(server.URL + "/api/v4")}, policy.clientOptions()...)
+	client, err := gitlab.NewClient("token", options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, calls
+}
+
+func TestRetryPolicy(t *testing.T) {
+	t.Run("Retries reads when GitLab is unavailable", func(t *testing.T) {
+		client, calls := newFlakyGitlab(t, 2, http.StatusBadGateway, nil)
+		_, _, err := client.MergeRequests.GetMergeRequest(1, 1, nil)
+		assert(t, err, nil)
+		assert(t, calls.Load(), int32(3))
+	})
+	t.Run("Gives up after the maximum number of attempts", func(t *testing.T) {
+		client, calls := newFlakyGitlab(t, 5, http.StatusServiceUnavailable, nil)
+		_, _, err := client.MergeRequests.GetMergeRequest(1, 1, nil)
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		assert(t, calls.Load(), int32(3))
+	})
+	t.Run("Does not retry changes that GitLab may have acted on", func(t *testing.T) {
+		client, calls := newFlakyGitlab(t, 1, http.StatusBadGateway, nil)
+		_, _, err := client.MergeRequests.AcceptMergeRequest(1, 1, nil)
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		assert(t, calls.Load(), int32(1))
+	})
+	t.Run("Retries changes that GitLab turned away", func(t *testing.T) {
+		client, calls := newFlakyGitlab(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"0"}})
+		_, _, err := client.MergeRequests.AcceptMergeRequest(1, 1, nil)
+		assert(t, err, nil)
+		assert(t, calls.Load(), int32(2))
+	})
+	t.Run("Retries changes whose connection failed", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		url := "http://" + listener.Addr().String()
+		listener.Close()
+
+		_, err = http.Post(url, "application/json", nil)
+		retry, _ := retryPolicy{}.checkRetry(context.Background(), nil, err)
+		assert(t, retry, true)
+	})
+}
+
+func TestRetryBackoff(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	policy := retryPolicy{now: func() time.Time { return now }}
+
+	t.Run("Backs off exponentially with jitter", func(t *testing.T) {
+		for attempt := range 4 {
+			wait := policy.backoff(100*time.Millisecond, time.Second, attempt, nil)
+			ceiling := min(100*time.Millisecond<<attempt, time.Second)
+			if wait < ceiling/2 || wait > ceiling {
+				t.Errorf("Attempt %d waited %s, expected between %s and %s", attempt, wait, ceiling/2, ceiling)
+			}
+		}
+	})
+	t.Run("Honors Retry-After", func(t *testing.T) {
+		res := &http.Response{Header: http.Header{"Retry-After": {"7"}}}
+		assert(t, policy.backoff(time.Millisecond, time.Second, 0, res), 7*time.Second)
+
+		res = &http.Response{Header: http.Header{"Retry-After": {now.Add(3 * time.Second).UTC().Format(http.TimeFormat)}}}
+		assert(t, policy.backoff(time.Millisecond, time.Second, 0, res), 3*time.Second)
+	})
+	t.Run("Waits for the rate limit to reset once it is used up", func(t *testing.T) {
+		res := &http.Response{Header: http.Header{"Ratelimit-Remaining": {"0"}, "Ratelimit-Reset": {"1700000020"}}}
+		assert(t, policy.backoff(time.Millisecond, time.Second, 0, res), 20*time.Second)
+
+		res = &http.Response{Header: http.Header{"Ratelimit-Reset": {"1700009999"}}}
+		assert(t, policy.backoff(time.Millisecond, time.Millisecond, 0, res) <= time.Millisecond, true)
+	})
+}
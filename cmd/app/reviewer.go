@@ -30,13 +30,17 @@ type reviewerService struct {
 	client MergeRequestUpdater
 }
 
+func (a reviewerService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPut: ReviewerUpdateResponse{}}
+}
+
 /* reviewersHandler adds or removes reviewers from an MR */
 func (a reviewerService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	payload := r.Context().Value(payload("payload")).(*ReviewerUpdateRequest)
 
-	mr, res, err := a.client.UpdateMergeRequest(a.projectInfo.ProjectId, a.projectInfo.MergeId, &gitlab.UpdateMergeRequestOptions{
+	mr, res, err := a.client.UpdateMergeRequest(a.projectId(r), a.mergeId(r), &gitlab.UpdateMergeRequestOptions{
 		ReviewerIDs: &payload.Ids,
-	})
+	}, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not modify merge request reviewers", http.StatusInternalServerError)
@@ -21,13 +21,17 @@ type revisionsService struct {
 	client RevisionsGetter
 }
 
+func (a revisionsService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodGet: RevisionsResponse{}}
+}
+
 /*
 revisionsHandler gets revision information about the current MR. This data is not used directly but is
 a precursor API call for other functionality
 */
 func (a revisionsService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
-	versionInfo, res, err := a.client.GetMergeRequestDiffVersions(a.projectInfo.ProjectId, a.projectInfo.MergeId, &gitlab.GetMergeRequestDiffVersionsOptions{})
+	versionInfo, res, err := a.client.GetMergeRequestDiffVersions(a.projectId(r), a.mergeId(r), &gitlab.GetMergeRequestDiffVersionsOptions{}, gitlab.WithContext(r.Context()))
 	if err != nil {
 		handleError(w, err, "Could not get diff version info", http.StatusInternalServerError)
 		return
@@ -16,10 +16,14 @@ type mergeRequestRevokerService struct {
 	client MergeRequestRevoker
 }
 
+func (a mergeRequestRevokerService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPost: SuccessResponse{}}
+}
+
 /* revokeHandler revokes approval for the current merge request */
 func (a mergeRequestRevokerService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
-	res, err := a.client.UnapproveMergeRequest(a.projectInfo.ProjectId, a.projectInfo.MergeId, nil, nil)
+	res, err := a.client.UnapproveMergeRequest(a.projectId(r), a.mergeId(r), gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not revoke approval", http.StatusInternalServerError)
@@ -0,0 +1,355 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
+)
+
+/* Standard JSON-RPC 2.0 error codes. Errors returned by a route use its HTTP status code instead. */
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+/* newRpcError builds a protocol error whose data has the same shape as the ErrorResponse returned by routes */
+func newRpcError(code int, message string, err error) rpcError {
+	errorCode := CodeInvalidRequest
+	if code == rpcMethodNotFound {
+		errorCode = CodeNotFound
+	}
+	return rpcError{Code: code, Message: message, Data: ErrorResponse{Message: message, Details: err.Error(), Code: errorCode}}
+}
+
+type rpcCancelParams struct {
+	ID json.RawMessage `json:"id"`
+}
+
+/*
+rpcServer speaks JSON-RPC 2.0 with LSP-style Content-Length framing. Every method is an HTTP method
+and a path, such as "POST /mr/discussions/list", and is dispatched through the same router used by
+the HTTP server so that all middleware and payload validation apply. The params are the request body.
+Streaming routes like "GET /events" send each event as an "$/event" notification until the request
+is cancelled with "$/cancelRequest".
+*/
+type rpcServer struct {
+	handler http.Handler
+	secret  string
+	out     io.Writer
+
+	writeMu  sync.Mutex
+	mu       sync.Mutex
+	inflight map[string]context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func newRpcServer(handler http.Handler, secret string, out io.Writer) *rpcServer {
+	return &rpcServer{
+		handler:  handler,
+		secret:   secret,
+		out:      out,
+		inflight: map[string]context.CancelFunc{},
+	}
+}
+
+/* StartStdioServer serves the API over stdin and stdout instead of an HTTP listener */
+func StartStdioServer(client *Client, projectInfo *ProjectInfo, GitInfo git.GitData) {
+	s := shutdownService{
+		sigCh: make(chan os.Signal, 1),
+	}
+
+	/* The secret never leaves the process, since every request is dispatched internally */
+	secret, err := newSessionSecret()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not generate server secret: %s\n", err)
+		os.Exit(1)
+	}
+
+	r := CreateRouter(client, projectInfo, &s, serverOptions(projectInfo, GitInfo, secret)...)
+
+	rpc := newRpcServer(r, secret, os.Stdout)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	/* The server stops when the /shutdown method is called or when the editor closes stdin */
+	go func() {
+		<-s.sigCh
+		cancel()
+	}()
+
+	err = rpc.Serve(ctx, os.Stdin)
+	for _, cleanup := range s.cleanups {
+		cleanup()
+	}
+	cancel()
+
+	/* Like the HTTP server, in-flight requests get a deadline to finish before the process exits */
+	drained := make(chan struct{})
+	go func() {
+		rpc.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(shutdownDrainTimeout):
+		fmt.Fprintln(os.Stderr, "Server could not shut down gracefully: in-flight requests did not finish")
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Server crashed: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+/* Serve reads requests until the input ends or the context is cancelled, handling each one concurrently */
+func (s *rpcServer) Serve(ctx context.Context, in io.Reader) error {
+	messages := make(chan []byte)
+	errs := make(chan error, 1)
+	go func() {
+		reader := bufio.NewReader(in)
+		for {
+			message, err := readRpcMessage(reader)
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case messages <- message:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case message := <-messages:
+			s.handleMessage(ctx, message)
+		}
+	}
+}
+
+func (s *rpcServer) handleMessage(ctx context.Context, message []byte) {
+	var req rpcRequest
+	err := json.Unmarshal(message, &req)
+	if err != nil {
+		s.writeError(nil, newRpcError(rpcParseError, "Could not parse JSON-RPC message", err))
+		return
+	}
+
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		s.writeError(req.ID, newRpcError(rpcInvalidRequest, "Invalid JSON-RPC request", errors.New("expected jsonrpc 2.0 and a method")))
+		return
+	}
+
+	if req.Method == "$/cancelRequest" {
+		var params rpcCancelParams
+		if json.Unmarshal(req.Params, &params) == nil {
+			s.mu.Lock()
+			if cancel, ok := s.inflight[string(params.ID)]; ok {
+				cancel()
+			}
+			s.mu.Unlock()
+		}
+		return
+	}
+
+	httpMethod, path, found := strings.Cut(req.Method, " ")
+	if !found || !strings.HasPrefix(path, "/") {
+		s.writeError(req.ID, newRpcError(rpcMethodNotFound, "Method not found", fmt.Errorf("expected a method like \"GET /mr/info\", got %q", req.Method)))
+		return
+	}
+
+	reqCtx, cancel := context.WithCancel(ctx)
+	if req.ID != nil {
+		s.mu.Lock()
+		s.inflight[string(req.ID)] = cancel
+		s.mu.Unlock()
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			if req.ID != nil {
+				s.mu.Lock()
+				delete(s.inflight, string(req.ID))
+				s.mu.Unlock()
+			}
+			cancel()
+		}()
+		s.dispatch(reqCtx, req, httpMethod, path)
+	}()
+}
+
+/* dispatch runs a single request through the router and translates the HTTP response into a JSON-RPC response */
+func (s *rpcServer) dispatch(ctx context.Context, req rpcRequest, httpMethod string, path string) {
+	var body io.Reader = http.NoBody
+	if len(req.Params) > 0 && string(req.Params) != "null" {
+		body = bytes.NewReader(req.Params)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, httpMethod, path, body)
+	if err != nil {
+		s.writeError(req.ID, newRpcError(rpcInvalidRequest, "Invalid method", err))
+		return
+	}
+	r.Header.Set("Authorization", "Bearer "+s.secret)
+
+	w := &rpcResponseWriter{responseRecorder: newResponseRecorder(), server: s}
+	s.handler.ServeHTTP(w, r)
+
+	if req.ID == nil { // Notifications never get a response
+		return
+	}
+
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	result := bytes.TrimSpace(w.body.Bytes())
+	if status >= 300 {
+		var errResponse ErrorResponse
+		message := http.StatusText(status)
+		if json.Unmarshal(result, &errResponse) == nil && errResponse.Message != "" {
+			message = errResponse.Message
+		}
+		var data any = json.RawMessage(result)
+		if !json.Valid(result) {
+			data = ErrorResponse{Message: message, Details: string(result), Code: statusErrorCode(status)}
+		}
+		s.writeError(req.ID, rpcError{Code: status, Message: message, Data: data})
+		return
+	}
+
+	if len(result) == 0 || !json.Valid(result) {
+		result = []byte("null")
+	}
+	s.write(rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result})
+}
+
+func (s *rpcServer) notify(method string, params any) {
+	s.write(rpcNotification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *rpcServer) writeError(id json.RawMessage, e rpcError) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	s.write(rpcResponse{JSONRPC: "2.0", ID: id, Error: &e})
+}
+
+func (s *rpcServer) write(message any) {
+	j, err := json.Marshal(message)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not encode JSON-RPC message: %s\n", err)
+		return
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(j), j) //nolint:errcheck
+}
+
+/* readRpcMessage reads a single message framed with a Content-Length header */
+func readRpcMessage(reader *bufio.Reader) ([]byte, error) {
+	headers, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil {
+		if errors.Is(err, io.EOF) && len(headers) == 0 {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("could not read message headers: %w", err)
+	}
+
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length header: %q", headers.Get("Content-Length"))
+	}
+
+	message := make([]byte, length)
+	_, err = io.ReadFull(reader, message)
+	if err != nil {
+		return nil, fmt.Errorf("could not read message body: %w", err)
+	}
+
+	return message, nil
+}
+
+/*
+rpcResponseWriter collects a route's response. When a streaming route flushes server-sent events,
+each complete event is forwarded as a notification instead of being collected.
+*/
+type rpcResponseWriter struct {
+	responseRecorder
+	server *rpcServer
+}
+
+func (w *rpcResponseWriter) Flush() {
+	if w.header.Get("Content-Type") != "text/event-stream" {
+		return
+	}
+
+	for {
+		frame, rest, found := bytes.Cut(w.body.Bytes(), []byte("\n\n"))
+		if !found {
+			return
+		}
+
+		for _, line := range bytes.Split(frame, []byte("\n")) {
+			if data, ok := bytes.CutPrefix(line, []byte("data: ")); ok && json.Valid(data) {
+				w.server.notify("$/event", json.RawMessage(data))
+			}
+		}
+
+		remaining := append([]byte{}, rest...)
+		w.body.Reset()
+		w.body.Write(remaining)
+	}
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
+)
+
+/* rpcTestClient drives an rpcServer through in-memory pipes, as Neovim would through a job's stdio */
+type rpcTestClient struct {
+	in     *io.PipeWriter
+	reader *bufio.Reader
+	cancel context.CancelFunc
+}
+
+func newRpcTestClient(t *testing.T, handler http.Handler) *rpcTestClient {
+	t.Helper()
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	server := newRpcServer(LoggingServer{handler: middleware(handler, withSecretCheck("s3cret"))}, "s3cret", outWriter)
+	go server.Serve(ctx, inReader) // nolint
+
+	t.Cleanup(func() {
+		cancel()
+		inWriter.Close()
+		outReader.Close()
+	})
+	return &rpcTestClient{in: inWriter, reader: bufio.NewReader(outReader), cancel: cancel}
+}
+
+func (c *rpcTestClient) send(t *testing.T, message string) {
+	t.Helper()
+	_, err := fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(message), message)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type rpcTestMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int           `json:"code"`
+		Message string        `json:"message"`
+		Data    ErrorResponse `json:"data"`
+	} `json:"error"`
+}
+
+func (c *rpcTestClient) receive(t *testing.T) rpcTestMessage {
+	t.Helper()
+	received := make(chan []byte)
+	go func() {
+		message, err := readRpcMessage(c.reader)
+		if err != nil {
+			close(received)
+			return
+		}
+		received <- message
+	}()
+
+	select {
+	case message, ok := <-received:
+		if !ok {
+			t.Fatal("Output ended before a message arrived")
+		}
+		var m rpcTestMessage
+		err := json.Unmarshal(message, &m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for a message")
+	}
+	return rpcTestMessage{}
+}
+
+func TestRpcServer(t *testing.T) {
+	m := http.NewServeMux()
+	m.Handle("/mr/info", middleware(
+		infoService{testProjectData, fakeMergeRequestGetter{}},
+		withMethodCheck(http.MethodGet),
+	))
+	m.Handle("/echo", middleware(
+		fakeHandler{},
+		withPayloadValidation(methodToPayload{http.MethodPost: newPayload[FakePayload]}),
+		withMethodCheck(http.MethodPost),
+	))
+
+	t.Run("Dispatches a method through the router", func(t *testing.T) {
+		client := newRpcTestClient(t, m)
+		client.send(t, `{"jsonrpc":"2.0","id":1,"method":"GET /mr/info"}`)
+		response := client.receive(t)
+		assert(t, string(response.ID), "1")
+		var info InfoResponse
+		err := json.Unmarshal(response.Result, &info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, info.Message, "Merge requests retrieved")
+	})
+	t.Run("Applies payload validation to params", func(t *testing.T) {
+		client := newRpcTestClient(t, m)
+		client.send(t, `{"jsonrpc":"2.0","id":"a","method":"POST /echo","params":{}}`)
+		response := client.receive(t)
+		assert(t, string(response.ID), `"a"`)
+		assert(t, response.Error.Code, http.StatusBadRequest)
+		assert(t, response.Error.Message, "Invalid payload")
+		assert(t, response.Error.Data.Details, "Foo is required")
+	})
+	t.Run("Reports the wrong HTTP method", func(t *testing.T) {
+		client := newRpcTestClient(t, m)
+		client.send(t, `{"jsonrpc":"2.0","id":2,"method":"POST /mr/info"}`)
+		response := client.receive(t)
+		assert(t, response.Error.Code, http.StatusMethodNotAllowed)
+	})
+	t.Run("Rejects methods that are not routes", func(t *testing.T) {
+		client := newRpcTestClient(t, m)
+		client.send(t, `{"jsonrpc":"2.0","id":3,"method":"info"}`)
+		response := client.receive(t)
+		assert(t, response.Error.Code, rpcMethodNotFound)
+	})
+	t.Run("Rejects malformed messages", func(t *testing.T) {
+		client := newRpcTestClient(t, m)
+		client.send(t, `{not json`)
+		response := client.receive(t)
+		assert(t, response.Error.Code, rpcParseError)
+		client.send(t, `{"id":4,"method":"GET /mr/info"}`)
+		response = client.receive(t)
+		assert(t, response.Error.Code, rpcInvalidRequest)
+	})
+	t.Run("Sends events from streaming routes as notifications until cancelled", func(t *testing.T) {
+		watcher := &fakeMergeRequestWatcher{}
+		d := data{state: newSessionState(ProjectInfo{ProjectId: "1", MergeId: 10}, git.GitData{})}
+		broker := newEventBroker(d, watcher, 5*time.Millisecond)
+		defer broker.stop()
+
+		events := http.NewServeMux()
+		events.Handle("/events", eventsService{d, broker})
+		client := newRpcTestClient(t, events)
+		client.send(t, `{"jsonrpc":"2.0","id":5,"method":"GET /events"}`)
+
+		time.Sleep(20 * time.Millisecond) // Let the first poll establish a baseline
+		watcher.addNote("abc", 1)
+		notification := client.receive(t)
+		assert(t, notification.Method, "$/event")
+		var event MergeRequestEvent
+		err := json.Unmarshal(notification.Params, &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, event.Type, EventNoteAdded)
+
+		client.send(t, `{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":5}}`)
+		response := client.receive(t)
+		assert(t, string(response.ID), "5")
+		assert(t, string(response.Result), "null")
+	})
+}
@@ -1,11 +1,16 @@
 package app
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
@@ -22,15 +27,19 @@ func StartServer(client *Client, projectInfo *ProjectInfo, GitInfo git.GitData)
 		sigCh: make(chan os.Signal, 1),
 	}
 
-	fr := attachmentReader{}
-	r := CreateRouter(
-		client,
-		projectInfo,
-		&s,
-		func(a *data) error { a.projectInfo = projectInfo; return nil },
-		func(a *data) error { a.gitInfo = &GitInfo; return nil },
-		func(a *data) error { err := attachEmojis(a, fr); return err },
-	)
+	secret := pluginOptions.Secret
+	if secret == "" {
+		var err error
+		secret, err = newSessionSecret()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not generate server secret: %s\n", err)
+			os.Exit(1)
+		}
+		/* This print is detected by the Lua code, and must come before the startup message */
+		fmt.Println("Server secret: ", secret)
+	}
+
+	r := CreateRouter(client, projectInfo, &s, serverOptions(projectInfo, GitInfo, secret)...)
 	l := createListener()
 
 	server := &http.Server{Handler: r}
@@ -46,20 +55,45 @@ func StartServer(client *Client, projectInfo *ProjectInfo, GitInfo git.GitData)
 		}
 	}()
 
-	port := l.Addr().(*net.TCPAddr).Port
-	err := checkServer(port)
+	err := checkServer(l)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Server did not respond: %s\n", err)
 		os.Exit(1)
 	}
 
 	/* This print is detected by the Lua code */
-	fmt.Println("Server started on port: ", port)
+	if addr, ok := l.Addr().(*net.UnixAddr); ok {
+		fmt.Println("Server started on socket: ", addr.Name)
+	} else {
+		fmt.Println("Server started on port: ", l.Addr().(*net.TCPAddr).Port)
+	}
 
 	/* Handles shutdown requests */
 	s.WatchForShutdown(server)
 }
 
+/* serverOptions configures the router with the project, the git repository and the session secret, for both transports */
+func serverOptions(projectInfo *ProjectInfo, gitInfo git.GitData, secret string) []optFunc {
+	fr := attachmentReader{}
+	return []optFunc{
+		func(a *data) error { a.state = newSessionState(*projectInfo, gitInfo); return nil },
+		/* A merge request served from the disk cache may turn out to be out of date once revalidated */
+		func(a *data) error { diskCache.OnSessionChange(a.state.Invalidate); return nil },
+		func(a *data) error { a.secret = secret; return nil },
+		func(a *data) error { err := attachEmojis(a, fr); return err },
+		func(a *data) error {
+			watcher, err := git.NewHeadWatcher(git.Git{})
+			if err != nil {
+				/* Not fatal, the branch can still be switched through the /session endpoint */
+				fmt.Fprintf(os.Stderr, "Could not watch for branch changes: %s\n", err)
+				return nil
+			}
+			a.headWatcher = watcher
+			return nil
+		},
+	}
+}
+
 /*
 CreateRouterAndApi wires up the router and attaches all handlers to their respective routes. It also
 iterates over all option functions to configure API fields such as the project information and default
@@ -67,20 +101,46 @@ file reader functionality
 */
 
 type data struct {
-	projectInfo *ProjectInfo
-	gitInfo     *git.GitData
+	state       *sessionState
 	emojiMap    EmojiMap
+	secret      string
+	headWatcher *git.HeadWatcher
+}
+
+/*
+mergeId returns the IID of the merge request a request is addressed to, either through an /mr/{iid}/ path
+or an iid query parameter, and otherwise the IID of the current merge request as withMr resolved it
+*/
+func (d data) mergeId(r *http.Request) int64 {
+	if iid, ok := r.Context().Value(mergeRequestIID).(int64); ok {
+		return iid
+	}
+	if mr, ok := r.Context().Value(currentMergeRequestKey).(currentMergeRequest); ok {
+		return mr.mergeId
+	}
+	return d.state.MergeId()
+}
+
+/* projectId returns the project withMr resolved the merge request in, and otherwise the current project */
+func (d data) projectId(r *http.Request) string {
+	if mr, ok := r.Context().Value(currentMergeRequestKey).(currentMergeRequest); ok {
+		return mr.projectId
+	}
+	return d.state.ProjectId()
 }
 
 type optFunc func(a *data) error
 
+/* How often HEAD is checked in the background, in addition to before every request */
+const headPollInterval = 2 * time.Second
+
 func CreateRouter(gitlabClient *Client, projectInfo *ProjectInfo, s *shutdownService, optFuncs ...optFunc) http.Handler {
-	m := http.NewServeMux()
+	m := newApiMux()
 
 	d := data{
-		projectInfo: &ProjectInfo{},
-		gitInfo:     &git.GitData{},
+		state: newSessionState(ProjectInfo{}, git.GitData{}),
 	}
+	gitService := timedGitManager{git.Git{}, metrics}
 
 	/* Mutates the API struct as necessary with configuration functions */
 	for _, optFunc := range optFuncs {
@@ -89,19 +149,19 @@ func CreateRouter(gitlabClient *Client, projectInfo *ProjectInfo, s *shutdownSer
 			if os.Getenv("DEBUG") != "" {
 				// TODO: We have some JSON files (emojis.json) we import relative to the binary in production and
 				// expect to break during debugging, do not throw when that occurs.
-				_, _ = fmt.Fprintf(os.Stdout, "Issue occured setting up router: %s\n", err)
+				_, _ = fmt.Fprintf(os.Stderr, "Issue occured setting up router: %s\n", err)
 			} else {
 				panic(err)
 			}
 		}
 	}
 
-	m.HandleFunc("/mr/approve", middleware(
+	m.Handle("/mr/approve", middleware(
 		mergeRequestApproverService{d, gitlabClient}, // These functions are called from bottom to top...
 		withMr(d, gitlabClient),
 		withMethodCheck(http.MethodPost),
 	))
-	m.HandleFunc("/mr/comment", middleware(
+	m.Handle("/mr/comment", middleware(
 		commentService{d, gitlabClient},
 		withMr(d, gitlabClient),
 		withPayloadValidation(methodToPayload{
@@ -111,74 +171,80 @@ func CreateRouter(gitlabClient *Client, projectInfo *ProjectInfo, s *shutdownSer
 		}),
 		withMethodCheck(http.MethodPost, http.MethodDelete, http.MethodPatch),
 	))
-	m.HandleFunc("/mr/merge", middleware(
+	m.Handle("/mr/merge", middleware(
 		mergeRequestAccepterService{d, gitlabClient},
 		withMr(d, gitlabClient),
 		withPayloadValidation(methodToPayload{http.MethodPost: newPayload[AcceptMergeRequestRequest]}),
 		withMethodCheck(http.MethodPost),
 	))
-	m.HandleFunc("/mr/discussions/list", middleware(
+	m.Handle("/mr/discussions/list", middleware(
 		discussionsListerService{d, gitlabClient},
+		withCoalescing(d, http.MethodPost),
 		withMr(d, gitlabClient),
 		withPayloadValidation(methodToPayload{http.MethodPost: newPayload[DiscussionsRequest]}),
 		withMethodCheck(http.MethodPost),
 	))
-	m.HandleFunc("/mr/discussions/resolve", middleware(
+	m.Handle("/mr/discussions/resolve", middleware(
 		discussionsResolutionService{d, gitlabClient},
 		withMr(d, gitlabClient),
 		withPayloadValidation(methodToPayload{http.MethodPut: newPayload[DiscussionResolveRequest]}),
 		withMethodCheck(http.MethodPut),
 	))
-	m.HandleFunc("/mr/info", middleware(
+	m.Handle("/mr/info", middleware(
 		infoService{d, gitlabClient},
+		withCoalescing(d, http.MethodGet),
 		withMr(d, gitlabClient),
 		withMethodCheck(http.MethodGet),
 	))
-	m.HandleFunc("/mr/assignee", middleware(
+	m.Handle("/mr/assignee", middleware(
 		assigneesService{d, gitlabClient},
 		withMr(d, gitlabClient),
 		withPayloadValidation(methodToPayload{http.MethodPut: newPayload[AssigneeUpdateRequest]}),
 		withMethodCheck(http.MethodPut),
 	))
-	m.HandleFunc("/mr/summary", middleware(
+	m.Handle("/mr/summary", middleware(
 		summaryService{d, gitlabClient},
 		withMr(d, gitlabClient),
 		withPayloadValidation(methodToPayload{http.MethodPut: newPayload[SummaryUpdateRequest]}),
 		withMethodCheck(http.MethodPut),
 	))
-	m.HandleFunc("/mr/reviewer", middleware(
+	m.Handle("/mr/reviewer", middleware(
 		reviewerService{d, gitlabClient},
 		withMr(d, gitlabClient),
 		withPayloadValidation(methodToPayload{http.MethodPut: newPayload[ReviewerUpdateRequest]}),
 		withMethodCheck(http.MethodPut),
 	))
-	m.HandleFunc("/mr/revisions", middleware(
+	m.Handle("/mr/revisions", middleware(
 		revisionsService{d, gitlabClient},
+		withCoalescing(d, http.MethodGet),
 		withMr(d, gitlabClient),
 		withMethodCheck(http.MethodGet),
 	))
-	m.HandleFunc("/mr/reply", middleware(
+	m.Handle("/mr/reply", middleware(
 		replyService{d, gitlabClient},
 		withMr(d, gitlabClient),
 		withPayloadValidation(methodToPayload{http.MethodPost: newPayload[ReplyRequest]}),
 		withMethodCheck(http.MethodPost),
 	))
-	m.HandleFunc("/mr/label", middleware(
+	m.Handle("/mr/label", middleware(
 		labelService{d, gitlabClient},
+		withCoalescing(d, http.MethodGet),
 		withMr(d, gitlabClient),
+		withMethodCheck(http.MethodGet, http.MethodPut),
 	))
-	m.HandleFunc("/mr/revoke", middleware(
+	m.Handle("/mr/revoke", middleware(
 		mergeRequestRevokerService{d, gitlabClient},
 		withMethodCheck(http.MethodPost),
 		withMr(d, gitlabClient),
 	))
-	m.HandleFunc("/mr/awardable/note/", middleware(
+	m.Handle("/mr/awardable/note/", middleware(
 		emojiService{d, gitlabClient},
 		withMethodCheck(http.MethodPost, http.MethodDelete),
 		withMr(d, gitlabClient),
 	))
-	m.HandleFunc("/mr/draft_notes/", middleware(
+	m.Handle("/mr/draft_notes/", middleware(
 		draftNoteService{d, gitlabClient},
+		withCoalescing(d, http.MethodGet),
 		withMr(d, gitlabClient),
 		withPayloadValidation(methodToPayload{
 			http.MethodPost:  newPayload[PostDraftNoteRequest],
@@ -186,69 +252,111 @@ func CreateRouter(gitlabClient *Client, projectInfo *ProjectInfo, s *shutdownSer
 		}),
 		withMethodCheck(http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete),
 	))
-	m.HandleFunc("/mr/draft_notes/publish", middleware(
+	m.Handle("/mr/draft_notes/publish", middleware(
 		draftNotePublisherService{d, gitlabClient},
 		withMr(d, gitlabClient),
 		withPayloadValidation(methodToPayload{http.MethodPost: newPayload[DraftNotePublishRequest]}),
 		withMethodCheck(http.MethodPost),
 	))
-	m.HandleFunc("/pipeline", middleware(
-		pipelineService{d, gitlabClient, git.Git{}},
+	broker := newEventBroker(d, gitlabClient, time.Duration(pluginOptions.EventsPollInterval)*time.Second)
+	d.state.OnInvalidate(broker.reset)
+	s.addCleanup(broker.stop)
+
+	/* When HEAD moves to another branch, serve that branch and look up its merge request again */
+	if d.headWatcher != nil {
+		d.headWatcher.OnChange(func(change git.BranchChange) {
+			d.state.SetBranch(change.To)
+			broker.publish(MergeRequestEvent{EventBranchChanged, change})
+		})
+		d.headWatcher.Watch(headPollInterval)
+		s.addCleanup(d.headWatcher.Stop)
+	}
+
+	m.Handle("/events", middleware(
+		eventsService{d, broker},
 		withMethodCheck(http.MethodGet),
 	))
-	m.HandleFunc("/pipeline/trigger/", middleware(
-		pipelineService{d, gitlabClient, git.Git{}},
+	m.Handle("/pipeline", middleware(
+		pipelineService{d, gitlabClient, gitService},
+		withCoalescing(d, http.MethodGet),
+		withMethodCheck(http.MethodGet),
+	))
+	m.Handle("/pipeline/trigger/", middleware(
+		pipelineService{d, gitlabClient, gitService},
 		withMethodCheck(http.MethodPost),
 	))
-	m.HandleFunc("/users/me", middleware(
+	m.Handle("/users/me", middleware(
 		meService{d, gitlabClient},
+		withCoalescing(d, http.MethodGet),
 		withMethodCheck(http.MethodGet),
 	))
-	m.HandleFunc("/attachment", middleware(
+	m.Handle("/session", middleware(
+		sessionService{d, gitlabClient, gitService},
+		withPayloadValidation(methodToPayload{http.MethodPut: newPayload[SessionUpdateRequest]}),
+		withMethodCheck(http.MethodGet, http.MethodPut),
+	))
+	m.Handle("/attachment", middleware(
 		attachmentService{data: d, client: gitlabClient, fileReader: attachmentReader{}},
 		withPayloadValidation(methodToPayload{http.MethodPost: newPayload[AttachmentRequest]}),
 		withMethodCheck(http.MethodPost),
 	))
-	m.HandleFunc("/create_mr", middleware(
+	m.Handle("/create_mr", middleware(
 		mergeRequestCreatorService{d, gitlabClient},
 		withPayloadValidation(methodToPayload{http.MethodPost: newPayload[CreateMrRequest]}),
 		withMethodCheck(http.MethodPost),
 	))
-	m.HandleFunc("/job", middleware(
+	m.Handle("/job", middleware(
 		traceFileService{d, gitlabClient},
 		withPayloadValidation(methodToPayload{http.MethodGet: newPayload[JobTraceRequest]}),
 		withMethodCheck(http.MethodGet),
 	))
-	m.HandleFunc("/project/members", middleware(
+	m.Handle("/project/members", middleware(
 		projectMemberService{d, gitlabClient},
+		withCoalescing(d, http.MethodGet),
 		withMethodCheck(http.MethodGet),
 	))
-	m.HandleFunc("/merge_requests", middleware(
+	m.Handle("/merge_requests", middleware(
 		mergeRequestListerService{d, gitlabClient},
 		withPayloadValidation(methodToPayload{http.MethodPost: newPayload[gitlab.ListProjectMergeRequestsOptions]}), // TODO: How to validate external object
 		withMethodCheck(http.MethodPost),
 	))
-	m.HandleFunc("/merge_requests_by_username", middleware(
+	m.Handle("/merge_requests_by_username", middleware(
 		mergeRequestListerByUsernameService{d, gitlabClient},
 		withPayloadValidation(methodToPayload{http.MethodPost: newPayload[MergeRequestByUsernameRequest]}),
 		withMethodCheck(http.MethodPost),
 	))
-	m.HandleFunc("/shutdown", middleware(
+	m.Handle("/shutdown", middleware(
 		*s,
 		withPayloadValidation(methodToPayload{http.MethodPost: newPayload[ShutdownRequest]}),
 		withMethodCheck(http.MethodPost),
 	))
 
-	m.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = fmt.Fprintln(w, "pong")
-	})
+	m.Handle("/openapi.json", middleware(
+		openApiService{m},
+		withMethodCheck(http.MethodGet),
+	))
 
-	m.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, _ = fmt.Fprintf(w, `{"version":"%s"}`, version)
-	})
+	m.Handle("/debug/metrics", middleware(
+		metricsService{metrics},
+		withMethodCheck(http.MethodGet),
+	))
+	m.Handle("/debug/diagnostics", middleware(
+		diagnosticsService{},
+		withMethodCheck(http.MethodGet),
+	))
+
+	if pluginOptions.Debug.Pprof {
+		attachPprof(m)
+	}
+
+	m.Handle("/ping", middleware(
+		pingService{},
+		withMethodCheck(http.MethodGet),
+	))
+	m.Handle("/version", middleware(
+		versionService{},
+		withMethodCheck(http.MethodGet),
+	))
 
 	// Default 404 handler
 	m.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -259,26 +367,107 @@ func CreateRouter(gitlabClient *Client, projectInfo *ProjectInfo, s *shutdownSer
 		w.WriteHeader(http.StatusOK)
 	})
 
-	return LoggingServer{handler: m}
+	activity := newActivityTracker()
+	startWatchdogs(s, activity)
+
+	handler := middleware(m, withTimeouts(m), withMrAddressing(), withHeadCheck(d.headWatcher), withActivityTracking(activity), withSecretCheck(d.secret, "/ping"))
+
+	/* The batch endpoint dispatches back into the router, so it is registered once the full handler exists */
+	m.Handle("/batch", middleware(
+		batchService{handler},
+		withPayloadValidation(methodToPayload{http.MethodPost: newPayload[BatchRequest]}),
+		withMethodCheck(http.MethodPost),
+	))
+
+	return LoggingServer{handler: handler}
+}
+
+/* newSessionSecret generates the random secret that every request to the server must present as a bearer token */
+func newSessionSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+/* pingService answers without the secret, so that the plugin can tell when the server is up */
+type pingService struct{}
+
+func (a pingService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodGet: plainText{}}
+	r.public = true
+}
+
+func (a pingService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprintln(w, "pong")
+}
+
+/* versionService reports the version of the server, which the plugin compares with its own to rebuild it */
+type versionService struct{}
+
+func (a versionService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodGet: VersionResponse{}}
+}
+
+func (a versionService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(VersionResponse{Version: version})
+	if err != nil {
+		handleError(w, err, "Could not encode response", http.StatusInternalServerError)
+	}
 }
 
 /* checkServer pings the server repeatedly for 1 full second after startup in order to notify the plugin that the server is ready */
-func checkServer(port int) error {
+func checkServer(l net.Listener) error {
+	client, baseUrl := newListenerClient(l)
 	for i := 0; i < 10; i++ {
-		resp, err := http.Get("http://localhost:" + fmt.Sprintf("%d", port) + "/ping")
+		resp, err := client.Get(baseUrl + "/ping")
 		if resp != nil && resp.StatusCode == 200 && err == nil {
+			resp.Body.Close()
 			return nil
 		}
+		if resp != nil {
+			resp.Body.Close()
+		}
 		time.Sleep(100 * time.Microsecond)
 	}
 
 	return errors.New("could not start server")
 }
 
-/* Creates a TCP listener on the port specified by the user or a random port */
+/* newListenerClient returns an HTTP client and base URL that can reach the server behind the given listener */
+func newListenerClient(l net.Listener) (*http.Client, string) {
+	addr, ok := l.Addr().(*net.UnixAddr)
+	if !ok {
+		return http.DefaultClient, fmt.Sprintf("http://localhost:%d", l.Addr().(*net.TCPAddr).Port)
+	}
+
+	tr := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", addr.Name)
+		},
+	}
+
+	return &http.Client{Transport: tr}, "http://localhost"
+}
+
+/*
+Creates a Unix domain socket listener when a socket path is configured, otherwise
+a TCP listener on the port specified by the user or a random port
+*/
 func createListener() (l net.Listener) {
-	addr := fmt.Sprintf("localhost:%d", pluginOptions.Port)
-	l, err := net.Listen("tcp", addr)
+	var err error
+	if socketPath := pluginOptions.ConnectionSettings.SocketPath; socketPath != "" {
+		l, err = createSocketListener(socketPath)
+	} else {
+		l, err = net.Listen("tcp", fmt.Sprintf("localhost:%d", pluginOptions.Port))
+	}
+
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error starting server: %s\n", err)
 		os.Exit(1)
@@ -286,3 +475,75 @@ func createListener() (l net.Listener) {
 
 	return l
 }
+
+/*
+createSocketListener listens on a Unix domain socket that only the current user can connect to.
+A stale socket left behind by a crashed server is removed first. The socket is bound inside a
+directory only the current user can enter and moved into place once its permissions are set, so
+there is no moment where another user could connect to it. The socket file is removed again
+when the listener is closed during shutdown.
+*/
+func createSocketListener(socketPath string) (net.Listener, error) {
+	if info, err := os.Lstat(socketPath); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, fmt.Errorf("could not remove stale socket: %w", err)
+		}
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(socketPath), ".gl-")
+	if err != nil {
+		return nil, fmt.Errorf("could not create socket directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	/* Kept short, since socket paths are limited to about a hundred bytes */
+	tempPath := filepath.Join(dir, "s")
+	l, err := net.Listen("unix", tempPath)
+	if err != nil {
+		return nil, err
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tempPath, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("could not set socket permissions: %w", err)
+	}
+
+	if err := os.Rename(tempPath, socketPath); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("could not move socket into place: %w", err)
+	}
+
+	info, err := os.Lstat(socketPath)
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("could not find socket: %w", err)
+	}
+
+	return socketListener{l, socketPath, info}, nil
+}
+
+/*
+socketListener reports the path the socket was moved to and removes it when closed, unless another server has
+put its own socket there in the meantime, as happens during a restart
+*/
+type socketListener struct {
+	net.Listener
+	path string
+	info os.FileInfo
+}
+
+func (l socketListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+func (l socketListener) Close() error {
+	err := l.Listener.Close()
+	if info, statErr := os.Lstat(l.path); statErr == nil && os.SameFile(info, l.info) {
+		os.Remove(l.path)
+	}
+	return err
+}
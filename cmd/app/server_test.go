@@ -0,0 +1,133 @@
+package app
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSocketListener(t *testing.T) {
+	t.Run("Serves the router over a socket only the owner can use", func(t *testing.T) {
+		socketPath := filepath.Join(t.TempDir(), "gitlab.sock")
+		l, err := createSocketListener(socketPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		server := &http.Server{Handler: CreateRouter(&Client{}, &ProjectInfo{}, &shutdownService{})}
+		go server.Serve(l) // nolint
+		defer server.Close()
+
+		info, err := os.Stat(socketPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, info.Mode().Perm(), os.FileMode(0600))
+
+		entries, err := os.ReadDir(filepath.Dir(socketPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, len(entries), 1)
+
+		err = checkServer(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("Replaces a stale socket", func(t *testing.T) {
+		socketPath := filepath.Join(t.TempDir(), "gitlab.sock")
+		stale, err := net.Listen("unix", socketPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		stale.Close()
+
+		l, err := createSocketListener(socketPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+	})
+	t.Run("Leaves the socket of a newer server in place when closed", func(t *testing.T) {
+		socketPath := filepath.Join(t.TempDir(), "gitlab.sock")
+		old, err := createSocketListener(socketPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := createSocketListener(socketPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		old.Close()
+		if _, err := os.Stat(socketPath); err != nil {
+			t.Fatalf("Expected the newer socket to remain, got: %v", err)
+		}
+	})
+	t.Run("Refuses to replace a regular file", func(t *testing.T) {
+		socketPath := filepath.Join(t.TempDir(), "gitlab.sock")
+		err := os.WriteFile(socketPath, []byte{}, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = createSocketListener(socketPath)
+		if err == nil {
+			t.Error("Expected an error when the path is not a socket")
+		}
+	})
+	t.Run("Removes the socket on shutdown", func(t *testing.T) {
+		socketPath := filepath.Join(t.TempDir(), "gitlab.sock")
+		l, err := createSocketListener(socketPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s := shutdownService{sigCh: make(chan os.Signal, 1)}
+		server := &http.Server{Handler: CreateRouter(&Client{}, &ProjectInfo{}, &s)}
+		go server.Serve(l) // nolint
+		err = checkServer(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s.sigCh <- killer{}
+		s.WatchForShutdown(server)
+
+		_, err = os.Stat(socketPath)
+		if !os.IsNotExist(err) {
+			t.Errorf("Expected socket to be removed, got: %v", err)
+		}
+	})
+}
+
+func TestRouterSecret(t *testing.T) {
+	router := CreateRouter(&Client{}, &ProjectInfo{}, &shutdownService{}, func(a *data) error { a.secret = "s3cret"; return nil })
+	t.Run("Answers pings without the secret", func(t *testing.T) {
+		request := makeRequest(t, http.MethodGet, "/ping", nil)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, request)
+		assert(t, res.Code, http.StatusOK)
+	})
+	t.Run("Requires the secret on every other route", func(t *testing.T) {
+		for _, endpoint := range []string{"/version", "/mr/info", "/shutdown", "/does/not/exist"} {
+			request := makeRequest(t, http.MethodGet, endpoint, nil)
+			data, status := getFailData(t, router, request)
+			assert(t, status, http.StatusUnauthorized)
+			assert(t, data.Message, "Unauthorized")
+		}
+	})
+	t.Run("Serves routes when the secret is provided", func(t *testing.T) {
+		request := makeRequest(t, http.MethodGet, "/version", nil)
+		request.Header.Set("Authorization", "Bearer s3cret")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, request)
+		assert(t, res.Code, http.StatusOK)
+	})
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
+)
+
+type Session struct {
+	Branch          string `json:"branch"`
+	Remote          string `json:"remote"`
+	RemoteUrl       string `json:"remote_url"`
+	ProjectPath     string `json:"project_path"`
+	ProjectId       string `json:"project_id"`
+	MergeRequestIID int64  `json:"merge_request_iid"`
+	ChosenMrIID     int64  `json:"chosen_mr_iid"`
+
+	LastBranchChange *git.BranchChange `json:"last_branch_change"`
+}
+
+type SessionResponse struct {
+	SuccessResponse
+	Session Session `json:"session"`
+}
+
+type SessionUpdateRequest struct {
+	Branch      *string `json:"branch" validate:"omitempty,min=1"`
+	Remote      *string `json:"remote" validate:"omitempty,min=1"`
+	ChosenMrIID *int64  `json:"chosen_mr_iid" validate:"omitempty,min=0"`
+}
+
+type sessionService struct {
+	data
+	client     ProjectGetter
+	gitService git.GitManager
+}
+
+func (a sessionService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodGet: SessionResponse{}, http.MethodPut: SessionResponse{}}
+}
+
+/*
+sessionService reads and changes the branch, remote and merge request that the server is working with.
+Any change clears the cached merge request, so that the next merge request route looks it up again.
+*/
+func (a sessionService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	message := "Session retrieved"
+	if r.Method == http.MethodPut {
+		payload := r.Context().Value(payload("payload")).(*SessionUpdateRequest)
+		update := sessionUpdate{branch: payload.Branch, chosenMrIID: payload.ChosenMrIID}
+
+		if payload.Remote != nil {
+			gitData, err := git.NewGitData(*payload.Remote, GitInstances(), a.gitService)
+			if err != nil {
+				handleError(w, err, "Could not switch remote", http.StatusBadRequest)
+				return
+			}
+
+			/* The client talks to one instance, which is picked when the server starts */
+			if gitData.GitlabUrl != strings.TrimSuffix(pluginOptions.GitlabUrl, "/") {
+				err := fmt.Errorf("remote %s is on %s, but the server talks to %s, restart it to switch instances", *payload.Remote, gitData.GitlabUrl, pluginOptions.GitlabUrl)
+				handleError(w, err, "Could not switch remote", http.StatusBadRequest)
+				return
+			}
+
+			projectInfo, err := InitProjectSettings(r.Context(), a.client, gitData)
+			if err != nil {
+				handleError(w, err, "Could not switch remote", http.StatusBadRequest)
+				return
+			}
+
+			update.project = &projectSwitch{remote: *payload.Remote, gitInfo: gitData, projectId: projectInfo.ProjectId}
+		}
+
+		a.state.Update(update)
+		message = "Session updated"
+	}
+
+	gitInfo := a.state.GitInfo()
+	w.WriteHeader(http.StatusOK)
+	response := SessionResponse{
+		SuccessResponse: SuccessResponse{Message: message},
+		Session: Session{
+			Branch:          gitInfo.BranchName,
+			Remote:          a.state.Remote(),
+			RemoteUrl:       gitInfo.RemoteUrl,
+			ProjectPath:     gitInfo.ProjectPath(),
+			ProjectId:       a.state.ProjectId(),
+			MergeRequestIID: a.state.MergeId(),
+			ChosenMrIID:     a.state.ChosenMrIID(),
+		},
+	}
+	if a.headWatcher != nil {
+		response.Session.LastBranchChange = a.headWatcher.LastChange()
+	}
+
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		handleError(w, err, "Could not encode response", http.StatusInternalServerError)
+	}
+}
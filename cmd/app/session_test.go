@@ -0,0 +1,141 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+type fakeProjectGetter struct {
+	testBase
+}
+
+func (f fakeProjectGetter) GetProject(pid interface{}, opt *gitlab.GetProjectOptions, options ...gitlab.RequestOptionFunc) (*gitlab.Project, *gitlab.Response, error) {
+	resp, err := f.handleGitlabError()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &gitlab.Project{ID: 99}, resp, nil
+}
+
+func getSessionData(t *testing.T, svc http.Handler, request *http.Request) SessionResponse {
+	t.Helper()
+	res := httptest.NewRecorder()
+	svc.ServeHTTP(res, request)
+
+	var data SessionResponse
+	err := json.Unmarshal(res.Body.Bytes(), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func newTestSessionService(client ProjectGetter) (sessionService, data) {
+	d := data{state: newSessionState(
+		ProjectInfo{ProjectId: "1", MergeId: 10},
+		git.GitData{BranchName: "some-branch", Namespace: "namespace", ProjectName: "project"},
+	)}
+	gitManager := FakeGitManager{RemoteUrl: "git@gitlab.com:other-namespace/other-project.git", BranchName: "checked-out"}
+	return sessionService{d, client, gitManager}, d
+}
+
+func TestSessionHandler(t *testing.T) {
+	originalOptions := pluginOptions
+	defer func() { pluginOptions = originalOptions }()
+
+	withValidation := func(svc sessionService) http.Handler {
+		return middleware(
+			svc,
+			withPayloadValidation(methodToPayload{http.MethodPut: newPayload[SessionUpdateRequest]}),
+			withMethodCheck(http.MethodGet, http.MethodPut),
+		)
+	}
+
+	t.Run("Reads the session", func(t *testing.T) {
+		pluginOptions.ConnectionSettings.Remote = "origin"
+		svc, _ := newTestSessionService(fakeProjectGetter{})
+		data := getSessionData(t, withValidation(svc), makeRequest(t, http.MethodGet, "/session", nil))
+		assert(t, data.Message, "Session retrieved")
+		assert(t, data.Session.Branch, "some-branch")
+		assert(t, data.Session.Remote, "origin")
+		assert(t, data.Session.ProjectPath, "namespace/project")
+		assert(t, data.Session.MergeRequestIID, int64(10))
+	})
+	t.Run("Switches the branch and chosen MR and clears the cached MR", func(t *testing.T) {
+		svc, d := newTestSessionService(fakeProjectGetter{})
+		request := makeRequest(t, http.MethodPut, "/session", map[string]any{"branch": "feature", "chosen_mr_iid": 12})
+		data := getSessionData(t, withValidation(svc), request)
+		assert(t, data.Message, "Session updated")
+		assert(t, data.Session.Branch, "feature")
+		assert(t, data.Session.ChosenMrIID, int64(12))
+		assert(t, d.state.BranchName(), "feature")
+		assert(t, d.state.MergeId(), int64(0))
+		assert(t, d.state.ChosenMrIID(), int64(12))
+	})
+	t.Run("Forgets the chosen MR when a later update only switches the branch", func(t *testing.T) {
+		svc, d := newTestSessionService(fakeProjectGetter{})
+		getSessionData(t, withValidation(svc), makeRequest(t, http.MethodPut, "/session", map[string]any{"branch": "feature", "chosen_mr_iid": 12}))
+
+		data := getSessionData(t, withValidation(svc), makeRequest(t, http.MethodPut, "/session", map[string]any{"branch": "feature"}))
+		assert(t, data.Session.ChosenMrIID, int64(12))
+
+		data = getSessionData(t, withValidation(svc), makeRequest(t, http.MethodPut, "/session", map[string]any{"branch": "other"}))
+		assert(t, data.Session.ChosenMrIID, int64(0))
+		assert(t, d.state.ChosenMrIID(), int64(0))
+	})
+	t.Run("Switches the remote and reloads the project", func(t *testing.T) {
+		svc, d := newTestSessionService(fakeProjectGetter{})
+		request := makeRequest(t, http.MethodPut, "/session", map[string]any{"remote": "upstream"})
+		data := getSessionData(t, withValidation(svc), request)
+		assert(t, data.Session.Remote, "upstream")
+		assert(t, data.Session.ProjectPath, "other-namespace/other-project")
+		assert(t, data.Session.Branch, "checked-out")
+		assert(t, d.state.ProjectId(), "99")
+		assert(t, d.state.MergeId(), int64(0))
+	})
+	t.Run("Applies every change of one request with a single invalidation", func(t *testing.T) {
+		svc, d := newTestSessionService(fakeProjectGetter{})
+		var invalidations int
+		d.state.OnInvalidate(func() { invalidations++ })
+		request := makeRequest(t, http.MethodPut, "/session", map[string]any{"remote": "upstream", "branch": "feature", "chosen_mr_iid": 12})
+		data := getSessionData(t, withValidation(svc), request)
+		assert(t, invalidations, 1)
+		assert(t, data.Session.Remote, "upstream")
+		assert(t, data.Session.Branch, "feature")
+		assert(t, data.Session.ChosenMrIID, int64(12))
+	})
+	t.Run("Leaves the session alone when the remote cannot be loaded", func(t *testing.T) {
+		pluginOptions.ConnectionSettings.Remote = "origin"
+		svc, d := newTestSessionService(fakeProjectGetter{testBase{errFromGitlab: true}})
+		request := makeRequest(t, http.MethodPut, "/session", map[string]any{"remote": "upstream"})
+		data, status := getFailData(t, withValidation(svc), request)
+		assert(t, status, http.StatusBadRequest)
+		assert(t, data.Message, "Could not switch remote")
+		assert(t, d.state.Remote(), "origin")
+		assert(t, d.state.MergeId(), int64(10))
+		assert(t, d.state.BranchName(), "some-branch")
+	})
+	t.Run("Refuses to switch to a remote on another instance", func(t *testing.T) {
+		pluginOptions = PluginOptions{GitlabUrl: "https://git.example.com"}
+		pluginOptions.Instances = []InstanceOptions{{GitlabUrl: "https://gitlab.com"}}
+		pluginOptions.ConnectionSettings.Remote = "origin"
+		svc, d := newTestSessionService(fakeProjectGetter{})
+		request := makeRequest(t, http.MethodPut, "/session", map[string]any{"remote": "upstream"})
+		data, status := getFailData(t, withValidation(svc), request)
+		assert(t, status, http.StatusBadRequest)
+		assert(t, data.Details, "remote upstream is on https://gitlab.com, but the server talks to https://git.example.com, restart it to switch instances")
+		assert(t, d.state.Remote(), "origin")
+	})
+	t.Run("Rejects an empty branch", func(t *testing.T) {
+		svc, _ := newTestSessionService(fakeProjectGetter{})
+		request := makeRequest(t, http.MethodPut, "/session", map[string]any{"branch": ""})
+		data, status := getFailData(t, withValidation(svc), request)
+		assert(t, status, http.StatusBadRequest)
+		assert(t, data.Message, "Invalid payload")
+	})
+}
@@ -21,15 +21,41 @@ type ShutdownHandler interface {
 }
 
 type shutdownService struct {
-	sigCh chan os.Signal
+	sigCh    chan os.Signal
+	cleanups []func()
+}
+
+func (a shutdownService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPost: SuccessResponse{}}
+}
+
+/* addCleanup registers a function that stops a long-running part of the server, such as an open stream, before shutdown */
+func (s *shutdownService) addCleanup(f func()) {
+	s.cleanups = append(s.cleanups, f)
+}
+
+/* requestShutdown stops the server, from the /shutdown route or from a watchdog. Repeated requests are ignored. */
+func (s *shutdownService) requestShutdown() {
+	select {
+	case s.sigCh <- killer{}:
+	default:
+	}
 }
 
 func (s shutdownService) WatchForShutdown(server *http.Server) {
 	/* Handles shutdown requests */
 	<-s.sigCh
-	err := server.Shutdown(context.Background())
+	for _, cleanup := range s.cleanups {
+		cleanup()
+	}
+
+	/* In-flight requests get a deadline to finish, so that a hanging request cannot keep the process alive */
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownDrainTimeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Server could not shut down gracefully: %s\n", err)
+		server.Close() // nolint
 		os.Exit(1)
 	}
 }
@@ -55,6 +81,6 @@ func (s shutdownService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		handleError(w, err, "Could not encode response", http.StatusInternalServerError)
 	} else {
-		s.sigCh <- killer{}
+		s.requestShutdown()
 	}
 }
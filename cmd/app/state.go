@@ -0,0 +1,209 @@
+package app
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
+)
+
+/*
+sessionState holds everything about the session that can change while the server runs: the project,
+the branch, the remote, the chosen merge request and the merge request resolved for them. Services share
+one sessionState and only go through its methods, so that requests, the /session endpoint and the HEAD
+watcher never read a half-written session.
+*/
+type sessionState struct {
+	mu          sync.RWMutex
+	projectId   string
+	mergeId     int64
+	gitInfo     git.GitData
+	remote      string
+	chosenMrIID int64
+
+	/* Incremented on every invalidation, so that a resolution started for an older session is discarded */
+	generation uint64
+	resolving  *mergeIdResolution
+	hooks      []func()
+}
+
+/* mergeIdResolution is a lookup of the current merge request that concurrent requests wait on together */
+type mergeIdResolution struct {
+	done chan struct{}
+	mr   currentMergeRequest
+	err  error
+}
+
+/* currentMergeRequest is the resolved merge request together with the project it was resolved in */
+type currentMergeRequest struct {
+	projectId string
+	mergeId   int64
+}
+
+/* mergeIdQuery is the part of the session that decides which merge request is the current one */
+type mergeIdQuery struct {
+	ProjectId   string
+	BranchName  string
+	ChosenMrIID int64
+}
+
+func newSessionState(projectInfo ProjectInfo, gitInfo git.GitData) *sessionState {
+	return &sessionState{
+		projectId:   projectInfo.ProjectId,
+		mergeId:     projectInfo.MergeId,
+		gitInfo:     gitInfo,
+		remote:      pluginOptions.ConnectionSettings.Remote,
+		chosenMrIID: pluginOptions.ChosenMrIID,
+	}
+}
+
+func (s *sessionState) ProjectId() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.projectId
+}
+
+/* MergeId returns the resolved merge request, or 0 if it has not been resolved since the last invalidation */
+func (s *sessionState) MergeId() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mergeId
+}
+
+func (s *sessionState) GitInfo() git.GitData {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.gitInfo
+}
+
+func (s *sessionState) BranchName() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.gitInfo.BranchName
+}
+
+func (s *sessionState) Remote() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.remote
+}
+
+func (s *sessionState) ChosenMrIID() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.chosenMrIID
+}
+
+/* SetBranch switches to another branch and invalidates the merge request. A merge request chosen on the old branch is forgotten. */
+func (s *sessionState) SetBranch(branch string) {
+	s.Update(sessionUpdate{branch: &branch})
+}
+
+/* sessionUpdate is a set of changes to the session. Fields left nil are kept. */
+type sessionUpdate struct {
+	project     *projectSwitch
+	branch      *string
+	chosenMrIID *int64
+}
+
+/* projectSwitch moves the session to the repository behind another remote */
+type projectSwitch struct {
+	remote    string
+	gitInfo   git.GitData
+	projectId string
+}
+
+/*
+Update applies several changes at once and invalidates the merge request a single time. A new project comes
+first, so that a branch in the same update is applied on top of the branch of the new remote. The chosen merge
+request belongs to the branch it was chosen on, so it is forgotten when the branch changes, unless the same
+update chooses one.
+*/
+func (s *sessionState) Update(u sessionUpdate) {
+	s.update(func() {
+		branch := s.gitInfo.BranchName
+		if u.project != nil {
+			s.remote = u.project.remote
+			s.gitInfo = u.project.gitInfo
+			s.projectId = u.project.projectId
+		}
+		if u.branch != nil {
+			s.gitInfo.BranchName = *u.branch
+		}
+		if u.chosenMrIID != nil {
+			s.chosenMrIID = *u.chosenMrIID
+		} else if s.gitInfo.BranchName != branch {
+			s.chosenMrIID = 0
+		}
+	})
+}
+
+/* Invalidate forgets the resolved merge request, so that the next request resolves it again */
+func (s *sessionState) Invalidate() {
+	s.update(func() {})
+}
+
+/* OnInvalidate registers a function that is called after every invalidation, outside of the lock */
+func (s *sessionState) OnInvalidate(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, f)
+}
+
+func (s *sessionState) update(change func()) {
+	s.mu.Lock()
+	change()
+	s.mergeId = 0
+	s.generation++
+	s.resolving = nil
+	hooks := append([]func(){}, s.hooks...)
+	s.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+/*
+ResolveMergeRequest returns the current merge request and its project, looking it up with resolve if it is not
+known yet. Both come from the same session, so a caller never pairs a merge request with the project of another
+one. Concurrent callers share a single lookup. Errors are not cached, and a result is only stored if the session
+was not invalidated while the lookup was running.
+*/
+func (s *sessionState) ResolveMergeRequest(resolve func(mergeIdQuery) (int64, error)) (currentMergeRequest, error) {
+	s.mu.Lock()
+	if s.mergeId != 0 {
+		defer s.mu.Unlock()
+		return currentMergeRequest{s.projectId, s.mergeId}, nil
+	}
+
+	if call := s.resolving; call != nil {
+		s.mu.Unlock()
+		<-call.done
+		return call.mr, call.err
+	}
+
+	call := &mergeIdResolution{done: make(chan struct{})}
+	s.resolving = call
+	generation := s.generation
+	query := mergeIdQuery{ProjectId: s.projectId, BranchName: s.gitInfo.BranchName, ChosenMrIID: s.chosenMrIID}
+	s.mu.Unlock()
+
+	/* If resolve panics, the callers waiting on it get an error and the next one looks the merge request up again */
+	call.err = errors.New("the merge request lookup panicked")
+	defer func() {
+		s.mu.Lock()
+		if s.generation == generation {
+			s.resolving = nil
+			if call.err == nil {
+				s.mergeId = call.mr.mergeId
+			}
+		}
+		s.mu.Unlock()
+		close(call.done)
+	}()
+
+	mergeId, err := resolve(query)
+	call.mr, call.err = currentMergeRequest{query.ProjectId, mergeId}, err
+	return call.mr, call.err
+}
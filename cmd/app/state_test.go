@@ -0,0 +1,232 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+/* countingMergeRequests answers merge request lookups slowly enough for concurrent requests to overlap */
+type countingMergeRequests struct {
+	gitlab.MergeRequestsServiceInterface
+	lookups *atomic.Int32
+}
+
+func (f countingMergeRequests) ListProjectMergeRequests(pid interface{}, opt *gitlab.ListProjectMergeRequestsOptions, options ...gitlab.RequestOptionFunc) ([]*gitlab.BasicMergeRequest, *gitlab.Response, error) {
+	f.lookups.Add(1)
+	time.Sleep(10 * time.Millisecond)
+	return []*gitlab.BasicMergeRequest{{IID: 10}}, makeResponse(http.StatusOK), nil
+}
+
+func (f countingMergeRequests) GetMergeRequest(pid interface{}, mergeRequest int64, opt *gitlab.GetMergeRequestsOptions, options ...gitlab.RequestOptionFunc) (*gitlab.MergeRequest, *gitlab.Response, error) {
+	return &gitlab.MergeRequest{}, makeResponse(http.StatusOK), nil
+}
+
+func TestSessionState(t *testing.T) {
+	t.Run("Resolves the merge request once for concurrent callers", func(t *testing.T) {
+		state := newSessionState(ProjectInfo{ProjectId: "1"}, git.GitData{BranchName: "main"})
+		var lookups atomic.Int32
+		resolve := func(q mergeIdQuery) (int64, error) {
+			lookups.Add(1)
+			time.Sleep(10 * time.Millisecond)
+			return 10, nil
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				mr, err := state.ResolveMergeRequest(resolve)
+				if err != nil || mr != (currentMergeRequest{"1", 10}) {
+					t.Errorf("Got %+v, %v", mr, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert(t, lookups.Load(), int32(1))
+		assert(t, state.MergeId(), int64(10))
+	})
+	t.Run("Does not cache errors", func(t *testing.T) {
+		state := newSessionState(ProjectInfo{}, git.GitData{})
+		_, err := state.ResolveMergeRequest(func(q mergeIdQuery) (int64, error) { return 0, errors.New("some error") })
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		mr, _ := state.ResolveMergeRequest(func(q mergeIdQuery) (int64, error) { return 11, nil })
+		assert(t, mr.mergeId, int64(11))
+	})
+	t.Run("Discards a resolution that was started before an invalidation", func(t *testing.T) {
+		state := newSessionState(ProjectInfo{}, git.GitData{BranchName: "main"})
+		started, release := make(chan struct{}), make(chan struct{})
+		go func() {
+			_, _ = state.ResolveMergeRequest(func(q mergeIdQuery) (int64, error) {
+				close(started)
+				<-release
+				return 10, nil
+			})
+		}()
+
+		<-started
+		state.SetBranch("feature")
+		close(release)
+
+		var query mergeIdQuery
+		mr, _ := state.ResolveMergeRequest(func(q mergeIdQuery) (int64, error) { query = q; return 12, nil })
+		assert(t, mr.mergeId, int64(12))
+		assert(t, query.BranchName, "feature")
+	})
+	t.Run("Calls the invalidation hooks on every change", func(t *testing.T) {
+		state := newSessionState(ProjectInfo{MergeId: 10}, git.GitData{})
+		var calls int
+		state.OnInvalidate(func() { calls++ })
+
+		state.SetBranch("feature")
+		state.Update(sessionUpdate{chosenMrIID: gitlab.Ptr(int64(3))})
+		state.Update(sessionUpdate{project: &projectSwitch{"upstream", git.GitData{BranchName: "main"}, "99"}})
+		state.Invalidate()
+
+		assert(t, calls, 4)
+		assert(t, state.MergeId(), int64(0))
+		assert(t, state.ProjectId(), "99")
+		assert(t, state.Remote(), "upstream")
+		assert(t, state.ChosenMrIID(), int64(0))
+	})
+	t.Run("Applies an update with a single invalidation", func(t *testing.T) {
+		state := newSessionState(ProjectInfo{ProjectId: "1", MergeId: 10}, git.GitData{BranchName: "main"})
+		var calls int
+		state.OnInvalidate(func() { calls++ })
+
+		state.Update(sessionUpdate{
+			project:     &projectSwitch{"upstream", git.GitData{BranchName: "main"}, "99"},
+			branch:      gitlab.Ptr("feature"),
+			chosenMrIID: gitlab.Ptr(int64(3)),
+		})
+
+		assert(t, calls, 1)
+		assert(t, state.ProjectId(), "99")
+		assert(t, state.BranchName(), "feature")
+		assert(t, state.ChosenMrIID(), int64(3))
+	})
+	t.Run("Forgets the chosen merge request when the branch changes", func(t *testing.T) {
+		state := newSessionState(ProjectInfo{ProjectId: "1"}, git.GitData{BranchName: "main"})
+		state.Update(sessionUpdate{branch: gitlab.Ptr("a"), chosenMrIID: gitlab.Ptr(int64(7))})
+
+		state.SetBranch("a")
+		assert(t, state.ChosenMrIID(), int64(7))
+
+		state.SetBranch("other")
+		assert(t, state.ChosenMrIID(), int64(0))
+
+		var query mergeIdQuery
+		_, _ = state.ResolveMergeRequest(func(q mergeIdQuery) (int64, error) {
+			query = q
+			return 1, nil
+		})
+		assert(t, query, mergeIdQuery{ProjectId: "1", BranchName: "other"})
+	})
+	t.Run("Releases the waiting callers when the lookup panics", func(t *testing.T) {
+		state := newSessionState(ProjectInfo{ProjectId: "1"}, git.GitData{BranchName: "main"})
+		release := make(chan struct{})
+
+		first := make(chan any)
+		go func() {
+			defer func() { first <- recover() }()
+			_, _ = state.ResolveMergeRequest(func(mergeIdQuery) (int64, error) {
+				<-release
+				panic("boom")
+			})
+		}()
+		for {
+			state.mu.RLock()
+			started := state.resolving != nil
+			state.mu.RUnlock()
+			if started {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		second := make(chan error)
+		go func() {
+			_, err := state.ResolveMergeRequest(func(mergeIdQuery) (int64, error) { return 0, errors.New("not found") })
+			second <- err
+		}()
+
+		close(release)
+		assert(t, <-first, any("boom"))
+		if err := <-second; err == nil {
+			t.Error("Expected the waiting caller to get an error")
+		}
+
+		mr, err := state.ResolveMergeRequest(func(mergeIdQuery) (int64, error) { return 3, nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, mr.mergeId, int64(3))
+	})
+}
+
+/* Run with -race: parallel requests resolve and switch the session through the full router */
+func TestRouterConcurrency(t *testing.T) {
+	var lookups atomic.Int32
+	client := &Client{MergeRequestsServiceInterface: countingMergeRequests{lookups: &lookups}}
+	router := CreateRouter(client, &ProjectInfo{}, &shutdownService{},
+		func(a *data) error {
+			a.state = newSessionState(ProjectInfo{ProjectId: "1"}, git.GitData{BranchName: "main"})
+			return nil
+		},
+		func(a *data) error { a.secret = "s3cret"; return nil },
+	)
+
+	send := func(method, endpoint string, body any) int {
+		request := makeRequest(t, method, endpoint, body)
+		request.Header.Set("Authorization", "Bearer s3cret")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, request)
+		return res.Code
+	}
+
+	t.Run("Resolves the merge request once for parallel first requests", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if status := send(http.MethodGet, "/mr/info", nil); status != http.StatusOK {
+					t.Errorf("Got status %d", status)
+				}
+			}()
+		}
+		wg.Wait()
+		assert(t, lookups.Load(), int32(1))
+	})
+	t.Run("Switches the session while requests are in flight", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(3)
+			go func() {
+				defer wg.Done()
+				send(http.MethodGet, "/mr/info", nil)
+			}()
+			go func() {
+				defer wg.Done()
+				send(http.MethodPut, "/session", map[string]any{"branch": "feature"})
+			}()
+			go func() {
+				defer wg.Done()
+				send(http.MethodGet, "/session", nil)
+			}()
+		}
+		wg.Wait()
+		assert(t, send(http.MethodGet, "/mr/info", nil), http.StatusOK)
+	})
+}
@@ -22,14 +22,18 @@ type summaryService struct {
 	client MergeRequestUpdater
 }
 
+func (a summaryService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodPut: SummaryUpdateResponse{}}
+}
+
 func (a summaryService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	payload := r.Context().Value(payload("payload")).(*SummaryUpdateRequest)
 
-	mr, res, err := a.client.UpdateMergeRequest(a.projectInfo.ProjectId, a.projectInfo.MergeId, &gitlab.UpdateMergeRequestOptions{
+	mr, res, err := a.client.UpdateMergeRequest(a.projectId(r), a.mergeId(r), &gitlab.UpdateMergeRequestOptions{
 		Description: &payload.Description,
 		Title:       &payload.Title,
-	})
+	}, gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Could not edit merge request summary", http.StatusInternalServerError)
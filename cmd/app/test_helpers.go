@@ -2,6 +2,7 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"testing"
 
 	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
+	"github.com/hashicorp/go-retryablehttp"
 	gitlab "gitlab.com/gitlab-org/api/client-go"
 )
 
@@ -56,10 +58,7 @@ func makeResponse(status int) *gitlab.Response {
 }
 
 var testProjectData = data{
-	projectInfo: &ProjectInfo{},
-	gitInfo: &git.GitData{
-		BranchName: "some-branch",
-	},
+	state: newSessionState(ProjectInfo{}, git.GitData{BranchName: "some-branch"}),
 }
 
 func getSuccessData(t *testing.T, svc http.Handler, request *http.Request) SuccessResponse {
@@ -96,10 +95,11 @@ func (f *testBase) handleGitlabError() (*gitlab.Response, error) {
 	if f.errFromGitlab {
 		return nil, errorFromGitlab
 	}
-	if f.status == 0 {
-		f.status = 200
+	status := f.status // Fakes are shared by concurrent calls, so leave them unchanged
+	if status == 0 {
+		status = 200
 	}
-	return makeResponse(f.status), nil
+	return makeResponse(status), nil
 }
 
 func checkErrorFromGitlab(t *testing.T, data ErrorResponse, msg string) {
@@ -112,6 +112,7 @@ func checkNon200(t *testing.T, data ErrorResponse, msg, endpoint string) {
 	t.Helper()
 	assert(t, data.Message, msg)
 	assert(t, data.Details, fmt.Sprintf("An error occurred on the %s endpoint", endpoint))
+	assert(t, data.GitlabStatus, 0)
 }
 
 type FakeGitManager struct {
@@ -136,3 +137,12 @@ func (f FakeGitManager) GetLatestCommitOnRemote(remote string, branchName string
 func (f FakeGitManager) GetProjectUrlFromNativeGitCmd(string) (url string, err error) {
 	return f.RemoteUrl, nil
 }
+
+/* contextFromOptions returns the context that a service passed to the GitLab client through gitlab.WithContext */
+func contextFromOptions(options []gitlab.RequestOptionFunc) context.Context {
+	request, _ := retryablehttp.NewRequest(http.MethodGet, "https://gitlab.com", nil)
+	for _, option := range options {
+		_ = option(request)
+	}
+	return request.Context()
+}
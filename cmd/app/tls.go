@@ -0,0 +1,120 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+/* The minimum TLS versions the connection settings accept, TLS 1.2 being the default */
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+/*
+newTLSConfig configures TLS for calls to Gitlab. A CA bundle is trusted in addition to the system's
+certificates, so that an instance with an internal CA does not need `insecure`, and a client certificate is
+presented to instances that ask for one.
+*/
+func newTLSConfig(settings TransportSettings) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: settings.Insecure,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if settings.MinTlsVersion != "" {
+		version, ok := tlsVersions[settings.MinTlsVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported minimum TLS version %q, expected 1.2 or 1.3", settings.MinTlsVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if settings.CaFile != "" {
+		pem, err := os.ReadFile(settings.CaFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", settings.CaFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if settings.ClientCert != "" || settings.ClientKey != "" {
+		if settings.ClientCert == "" || settings.ClientKey == "" {
+			return nil, errors.New("a client certificate needs both client_cert and client_key")
+		}
+		certificate, err := tls.LoadX509KeyPair(settings.ClientCert, settings.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
+
+/* certificateError names the certificate that was rejected, which the errors of crypto/x509 leave out */
+type certificateError struct {
+	err  error
+	cert *x509.Certificate
+}
+
+func (e certificateError) Error() string {
+	return fmt.Sprintf("%s (certificate subject %q, issuer %q)", e.err.Error(), distinguishedName(e.cert.Subject), distinguishedName(e.cert.Issuer))
+}
+
+func (e certificateError) Unwrap() error {
+	return e.err
+}
+
+func distinguishedName(n pkix.Name) string {
+	if s := n.String(); s != "" {
+		return s
+	}
+	return "empty"
+}
+
+/* rejectedCertificate finds the certificate that a TLS error is about */
+func rejectedCertificate(err error) *x509.Certificate {
+	var verificationErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &unknownAuthorityErr) && unknownAuthorityErr.Cert != nil:
+		return unknownAuthorityErr.Cert
+	case errors.As(err, &hostnameErr) && hostnameErr.Certificate != nil:
+		return hostnameErr.Certificate
+	case errors.As(err, &invalidErr) && invalidErr.Cert != nil:
+		return invalidErr.Cert
+	case errors.As(err, &verificationErr) && len(verificationErr.UnverifiedCertificates) > 0:
+		return verificationErr.UnverifiedCertificates[0]
+	}
+	return nil
+}
+
+/* certificateErrorTransport adds the subject and issuer of a rejected certificate to the error */
+type certificateErrorTransport struct {
+	next http.RoundTripper
+}
+
+func (t certificateErrorTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(r)
+	if err != nil {
+		if cert := rejectedCertificate(err); cert != nil {
+			return nil, certificateError{err, cert}
+		}
+	}
+	return res, err
+}
@@ -0,0 +1,191 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* testCertificate is a certificate and its key, signed by a test CA or by itself */
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	tls  tls.Certificate
+}
+
+func newTestCertificate(t *testing.T, subject string, isCA bool, parent *testCertificate) *testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: subject, Organization: []string{"Example"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCertificate{cert: cert, key: key, tls: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}
+}
+
+/* writePEM writes the certificate, and its key if a path is given, and returns the path of the certificate */
+func (c *testCertificate) writePEM(t *testing.T, keyPath string) string {
+	t.Helper()
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if keyPath != "" {
+		der, err := x509.MarshalECPrivateKey(c.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certPath
+}
+
+/* newTestTLSServer serves TLS with a certificate issued by ca, asking clients for certificates issued by clientCA if one is given */
+func newTestTLSServer(t *testing.T, ca *testCertificate, clientCA *testCertificate, maxVersion uint16) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.Config.ErrorLog = log.New(io.Discard, "", 0) // Handshakes fail on purpose
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{newTestCertificate(t, "gitlab.example.com", false, ca).tls},
+		MaxVersion:   maxVersion,
+	}
+	if clientCA != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(clientCA.cert)
+		server.TLS.ClientCAs = pool
+		server.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+/* getWithSettings makes a call through the transport built from the settings and returns the body of the response */
+func getWithSettings(t *testing.T, settings TransportSettings, url string) (string, error) {
+	t.Helper()
+	originalOptions := pluginOptions
+	t.Cleanup(func() { pluginOptions = originalOptions })
+	pluginOptions.ConnectionSettings.TransportSettings = settings
+
+	tr, err := newTransport()
+	if err != nil {
+		return "", err
+	}
+	res, err := (&http.Client{Transport: tr}).Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	return string(body), err
+}
+
+func TestTLSSettings(t *testing.T) {
+	ca := newTestCertificate(t, "Example Internal CA", true, nil)
+
+	t.Run("Names the subject and issuer of a certificate that is not trusted", func(t *testing.T) {
+		server := newTestTLSServer(t, ca, nil, 0)
+		_, err := getWithSettings(t, TransportSettings{}, server.URL)
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		if !strings.Contains(err.Error(), `certificate subject "CN=gitlab.example.com,O=Example", issuer "CN=Example Internal CA,O=Example"`) {
+			t.Errorf("Expected the subject and issuer in the error, got %s", err)
+		}
+		if !isPermanent(err) {
+			t.Errorf("Expected the error to still be permanent")
+		}
+	})
+	t.Run("Trusts the certificates of the CA bundle", func(t *testing.T) {
+		server := newTestTLSServer(t, ca, nil, 0)
+		_, err := getWithSettings(t, TransportSettings{CaFile: ca.writePEM(t, "")}, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("Presents the client certificate to an instance that asks for one", func(t *testing.T) {
+		clientCA := newTestCertificate(t, "Example Client CA", true, nil)
+		client := newTestCertificate(t, "someone", false, clientCA)
+		keyPath := filepath.Join(t.TempDir(), "key.pem")
+		certPath := client.writePEM(t, keyPath)
+		server := newTestTLSServer(t, ca, clientCA, 0)
+
+		_, err := getWithSettings(t, TransportSettings{CaFile: ca.writePEM(t, ""), ClientCert: certPath, ClientKey: keyPath}, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = getWithSettings(t, TransportSettings{CaFile: ca.writePEM(t, "")}, server.URL)
+		if err == nil {
+			t.Fatal("Expected the instance to turn down a client without a certificate")
+		}
+	})
+	t.Run("Refuses instances below the minimum TLS version", func(t *testing.T) {
+		server := newTestTLSServer(t, ca, nil, tls.VersionTLS12)
+		_, err := getWithSettings(t, TransportSettings{CaFile: ca.writePEM(t, ""), MinTlsVersion: "1.3"}, server.URL)
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		_, err = getWithSettings(t, TransportSettings{CaFile: ca.writePEM(t, ""), MinTlsVersion: "1.2"}, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("Reports settings that cannot be used", func(t *testing.T) {
+		tests := []struct {
+			settings TransportSettings
+			message  string
+		}{
+			{TransportSettings{MinTlsVersion: "1.1"}, `unsupported minimum TLS version "1.1", expected 1.2 or 1.3`},
+			{TransportSettings{CaFile: "/does/not/exist.pem"}, "could not read CA bundle: open /does/not/exist.pem: no such file or directory"},
+			{TransportSettings{ClientCert: "cert.pem"}, "a client certificate needs both client_cert and client_key"},
+		}
+		for _, tt := range tests {
+			_, err := newTLSConfig(tt.settings)
+			if err == nil {
+				t.Fatalf("Expected an error for %+v", tt.settings)
+			}
+			assert(t, err.Error(), tt.message)
+		}
+	})
+}
@@ -0,0 +1,294 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
+)
+
+/* Used when the auth settings do not name an environment variable */
+const defaultTokenEnv = "GITLAB_TOKEN"
+
+/* How long the credential helper or the token command may take */
+const tokenCommandTimeout = 10 * time.Second
+
+/* Replaces secrets in the diagnostics */
+const redacted = "[redacted]"
+
+/* The outcomes of trying one source of the auth token */
+const (
+	tokenUsed     = "used"
+	tokenEmpty    = "empty"
+	tokenFailed   = "failed"
+	tokenUnset    = "not_configured"
+	tokenNotTried = "not_tried"
+)
+
+/* TokenAttempt describes one source of the auth token, in the order they were tried */
+type TokenAttempt struct {
+	Source string `json:"source"`
+	Detail string `json:"detail,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+/* TokenResolution tells where the auth token came from */
+type TokenResolution struct {
+	Source   string         `json:"source"`
+	Attempts []TokenAttempt `json:"attempts"`
+}
+
+var tokenResolution TokenResolution
+
+/* tokenSource is one place the auth token may come from. An empty detail means the source is not configured. */
+type tokenSource struct {
+	name   string
+	detail string
+	read   func(ctx context.Context) (string, error)
+}
+
+func tokenSources(options PluginOptions) []tokenSource {
+	auth := options.Auth
+	env := auth.TokenEnv
+	if env == "" {
+		env = defaultTokenEnv
+	}
+	config := ""
+	if options.AuthToken != "" {
+		config = "auth_token"
+	}
+	credentialHelper := ""
+	if auth.CredentialHelper {
+		credentialHelper = options.GitlabUrl
+	}
+
+	return []tokenSource{
+		{"config", config, func(context.Context) (string, error) {
+			return options.AuthToken, nil
+		}},
+		{"env", env, func(context.Context) (string, error) {
+			return os.Getenv(env), nil
+		}},
+		{"file", auth.TokenFile, func(context.Context) (string, error) {
+			return readTokenFile(auth.TokenFile)
+		}},
+		{"git_credential", credentialHelper, func(ctx context.Context) (string, error) {
+			return git.Git{}.CredentialFill(ctx, options.GitlabUrl)
+		}},
+		{"command", redactedCommand(auth.TokenCommand), func(ctx context.Context) (string, error) {
+			return runTokenCommand(ctx, auth.TokenCommand)
+		}},
+	}
+}
+
+/*
+resolveAuthToken tries the sources of the auth token in order and returns the first token found: the token in
+the configuration, the environment variable, the token file, the git credential helper and the token command.
+*/
+func resolveAuthToken(ctx context.Context, options PluginOptions) (string, TokenResolution) {
+	resolution := TokenResolution{}
+	token := ""
+	for _, source := range tokenSources(options) {
+		attempt := TokenAttempt{Source: source.name, Detail: source.detail}
+		switch {
+		case source.detail == "":
+			attempt.Status = tokenUnset
+		case token != "":
+			attempt.Status = tokenNotTried
+		default:
+			ctx, cancel := context.WithTimeout(ctx, tokenCommandTimeout)
+			value, err := source.read(ctx)
+			cancel()
+			value = strings.TrimSpace(value)
+			switch {
+			case err != nil:
+				attempt.Status = tokenFailed
+				attempt.Error = err.Error()
+			case value == "":
+				attempt.Status = tokenEmpty
+			default:
+				attempt.Status = tokenUsed
+				token = value
+				resolution.Source = source.name
+			}
+		}
+		resolution.Attempts = append(resolution.Attempts, attempt)
+	}
+	return token, resolution
+}
+
+/* readTokenFile reads a token from a file, warning when other users may read it too */
+func readTokenFile(path string) (string, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, rest)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().Perm()&0077 != 0 && runtime.GOOS != "windows" {
+		logger.Warn("Token file can be read by other users", "file", path, "mode", info.Mode().Perm().String())
+	}
+
+	content, err := os.ReadFile(path)
+	return string(content), err
+}
+
+/* runTokenCommand runs a command, such as a password manager, that prints the token */
+func runTokenCommand(ctx context.Context, argv []string) (string, error) {
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("%w: %s", err, message)
+		}
+		return "", err
+	}
+	return string(output), nil
+}
+
+/* promptOutput is where the user is told how to sign in, which is stderr in stdio mode since stdout carries the JSON-RPC messages */
+func promptOutput() io.Writer {
+	if pluginOptions.Stdio {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+/*
+ResolveAuthToken finds the auth token for Gitlab and keeps it in the plugin options, or signs in with OAuth when
+the auth settings name an OAuth application. It fails with every source that was tried when none has a token.
+*/
+func ResolveAuthToken() error {
+	if pluginOptions.Auth.OAuth.ClientId != "" {
+		source, err := setupOAuth(context.Background(), promptOutput())
+		if err != nil {
+			return err
+		}
+		oauth = source
+		tokenResolution = TokenResolution{Source: "oauth", Attempts: []TokenAttempt{{Source: "oauth", Detail: source.store.path, Status: tokenUsed}}}
+		logger.Info("Signed in with OAuth", "file", source.store.path)
+		return nil
+	}
+
+	token, resolution := resolveAuthToken(context.Background(), pluginOptions)
+	tokenResolution = resolution
+	if token == "" {
+		tried := []string{}
+		for _, attempt := range resolution.Attempts {
+			if attempt.Status == tokenUnset {
+				continue
+			}
+			description := fmt.Sprintf("%s %s: %s", attempt.Source, attempt.Detail, attempt.Status)
+			if attempt.Error != "" {
+				description += " (" + attempt.Error + ")"
+			}
+			tried = append(tried, description)
+		}
+		return fmt.Errorf("no auth token found, tried %s", strings.Join(tried, "; "))
+	}
+
+	pluginOptions.AuthToken = token
+	logger.Info("Resolved auth token", "source", resolution.Source)
+	return nil
+}
+
+/* DiagnosticsResponse describes how the server was configured, without its secrets */
+type DiagnosticsResponse struct {
+	Version   string          `json:"version"`
+	Config    PluginOptions   `json:"config"`
+	AuthToken TokenResolution `json:"auth_token"`
+}
+
+/*
+redactedOptions returns the plugin options with the auth tokens, the server secret, the arguments of the token
+commands and the proxy credentials replaced
+*/
+func redactedOptions(options PluginOptions) PluginOptions {
+	options.AuthToken = redactedValue(options.AuthToken)
+	options.Secret = redactedValue(options.Secret)
+	options.Auth.TokenCommand = redactedArgs(options.Auth.TokenCommand)
+	options.ConnectionSettings.Proxy = redactedProxy(options.ConnectionSettings.Proxy)
+
+	instances := []InstanceOptions{}
+	for _, instance := range options.Instances {
+		instance.AuthToken = redactedValue(instance.AuthToken)
+		instance.Auth.TokenCommand = redactedArgs(instance.Auth.TokenCommand)
+		instance.ConnectionSettings.Proxy = redactedProxy(instance.ConnectionSettings.Proxy)
+		instances = append(instances, instance)
+	}
+	options.Instances = instances
+	return options
+}
+
+func redactedValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redacted
+}
+
+/* redactedArgs keeps the program of a command, since its arguments may hold secrets such as a vault path or a key */
+func redactedArgs(argv []string) []string {
+	if len(argv) <= 1 {
+		return argv
+	}
+	return []string{argv[0], redacted}
+}
+
+/* redactedCommand describes a command for the token resolution by its program alone */
+func redactedCommand(argv []string) string {
+	return strings.Join(redactedArgs(argv), " ")
+}
+
+/* redactedProxy replaces any user info of a proxy URL, since a username alone can be a token */
+func redactedProxy(proxy string) string {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return redactedValue(proxy)
+	}
+	if u.User == nil {
+		return proxy
+	}
+	u.User = url.User(redacted)
+	return u.String()
+}
+
+type diagnosticsService struct{}
+
+func (a diagnosticsService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodGet: DiagnosticsResponse{}}
+}
+
+/* ServeHTTP reports the version, the configuration and where the auth token came from */
+func (a diagnosticsService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	response := DiagnosticsResponse{
+		Version:   version,
+		Config:    redactedOptions(pluginOptions),
+		AuthToken: tokenResolution,
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		handleError(w, err, "Could not encode response", http.StatusInternalServerError)
+	}
+}
@@ -21,9 +21,13 @@ type meService struct {
 	client MeGetter
 }
 
+func (a meService) describe(r *route) {
+	r.responses = methodToResponse{http.MethodGet: UserResponse{}}
+}
+
 func (a meService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
-	user, res, err := a.client.CurrentUser()
+	user, res, err := a.client.CurrentUser(gitlab.WithContext(r.Context()))
 
 	if err != nil {
 		handleError(w, err, "Failed to get current user", http.StatusInternalServerError)
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+/* How often the editor process is checked when a parent PID is configured */
+const parentCheckInterval = time.Second
+
+/* How long in-flight requests get to finish once the server is shutting down */
+const shutdownDrainTimeout = 5 * time.Second
+
+/* activityTracker records when the server last finished a request and how many are still running */
+type activityTracker struct {
+	mu       sync.Mutex
+	inflight int
+	last     time.Time
+}
+
+func newActivityTracker() *activityTracker {
+	return &activityTracker{last: time.Now()}
+}
+
+// Counts every request as activity, so that the idle timeout only starts once the last request has finished
+func (a *activityTracker) handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.mu.Lock()
+		a.inflight++
+		a.mu.Unlock()
+
+		defer func() {
+			a.mu.Lock()
+			a.inflight--
+			a.last = time.Now()
+			a.mu.Unlock()
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+/* idleSince returns how long the server has been idle at the given time. Open requests, such as event streams, count as activity. */
+func (a *activityTracker) idleSince(now time.Time) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.inflight > 0 {
+		return 0
+	}
+	return now.Sub(a.last)
+}
+
+func withActivityTracking(a *activityTracker) mw {
+	return a.handle
+}
+
+/* watchIdle calls shutdown once the server has not handled a request for the given timeout */
+func watchIdle(a *activityTracker, timeout time.Duration, interval time.Duration, shutdown func(), stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if a.idleSince(now) >= timeout {
+				shutdown()
+				return
+			}
+		}
+	}
+}
+
+/* watchParent calls shutdown once the process with the given PID, usually the editor, has exited */
+func watchParent(pid int, interval time.Duration, shutdown func(), stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if !processAlive(pid) {
+				shutdown()
+				return
+			}
+		}
+	}
+}
+
+/*
+startWatchdogs shuts the server down when the editor that started it exits or when it has been idle for
+too long, so that crashed editors do not leave servers running. Both are off unless configured.
+*/
+func startWatchdogs(s *shutdownService, activity *activityTracker) {
+	stop := make(chan struct{})
+	s.addCleanup(func() { close(stop) })
+
+	if pluginOptions.ParentPid > 0 {
+		go watchParent(pluginOptions.ParentPid, parentCheckInterval, s.requestShutdown, stop)
+	}
+
+	if pluginOptions.IdleTimeout > 0 {
+		timeout := time.Duration(pluginOptions.IdleTimeout) * time.Second
+		go watchIdle(activity, timeout, min(timeout, time.Second), s.requestShutdown, stop)
+	}
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestActivityTracker(t *testing.T) {
+	t.Run("Counts open requests as activity", func(t *testing.T) {
+		activity := newActivityTracker()
+		started, release := make(chan struct{}), make(chan struct{})
+		handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			fakeHandler{}.ServeHTTP(w, r)
+		}), withActivityTracking(activity))
+
+		done := make(chan struct{})
+		go func() {
+			getSuccessData(t, handler, makeRequest(t, http.MethodGet, "/foo", nil))
+			close(done)
+		}()
+
+		<-started
+		assert(t, activity.idleSince(time.Now().Add(time.Hour)), time.Duration(0))
+		close(release)
+		<-done
+
+		if activity.idleSince(time.Now().Add(time.Hour)) < time.Hour {
+			t.Error("Expected the server to be idle once the request finished")
+		}
+	})
+}
+
+func TestWatchdogs(t *testing.T) {
+	t.Run("Shuts down after the idle timeout", func(t *testing.T) {
+		s := shutdownService{sigCh: make(chan os.Signal, 1)}
+		go watchIdle(newActivityTracker(), 10*time.Millisecond, time.Millisecond, s.requestShutdown, make(chan struct{}))
+
+		select {
+		case <-s.sigCh:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Server was not shut down when idle")
+		}
+	})
+	t.Run("Shuts down when the parent process exits", func(t *testing.T) {
+		cmd := exec.Command("go", "version")
+		if err := cmd.Run(); err != nil {
+			t.Skip("Could not start a process to watch")
+		}
+
+		s := shutdownService{sigCh: make(chan os.Signal, 1)}
+		go watchParent(cmd.Process.Pid, time.Millisecond, s.requestShutdown, make(chan struct{}))
+
+		select {
+		case <-s.sigCh:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Server was not shut down when the parent exited")
+		}
+	})
+	t.Run("Keeps running while the parent process is alive", func(t *testing.T) {
+		s := shutdownService{sigCh: make(chan os.Signal, 1)}
+		stop := make(chan struct{})
+		go watchParent(os.Getpid(), time.Millisecond, s.requestShutdown, stop)
+		defer close(stop)
+
+		select {
+		case <-s.sigCh:
+			t.Fatal("Server was shut down while the parent was alive")
+		case <-time.After(20 * time.Millisecond):
+		}
+	})
+	t.Run("Ignores repeated shutdown requests", func(t *testing.T) {
+		s := shutdownService{sigCh: make(chan os.Signal, 1)}
+		s.requestShutdown()
+		s.requestShutdown()
+		assert(t, len(s.sigCh), 1)
+	})
+	t.Run("Answers a shutdown request after a watchdog already asked for one", func(t *testing.T) {
+		s := shutdownService{sigCh: make(chan os.Signal, 1)}
+		s.requestShutdown()
+
+		done := make(chan struct{})
+		go func() {
+			request := makeRequest(t, http.MethodPost, "/shutdown", ShutdownRequest{})
+			getSuccessData(t, middleware(s, withPayloadValidation(methodToPayload{http.MethodPost: newPayload[ShutdownRequest]})), request)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Shutdown request blocked")
+		}
+	})
+}
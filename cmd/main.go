@@ -1,7 +1,7 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"log"
 	"os"
 
@@ -9,40 +9,50 @@ import (
 	"github.com/harrisoncramer/gitlab.nvim/cmd/app/git"
 )
 
-var pluginOptions app.PluginOptions
 var Version = "unknown" // Set via ldflags
 
 func main() {
 	log.SetFlags(0)
 
-	if len(os.Args) < 2 {
-		log.Fatal("Must provide server configuration")
+	pluginOptions, err := app.ReadPluginOptions(os.Args[1:], os.Stdin)
+	if err != nil {
+		log.Fatalf("Failure parsing plugin settings: %v", err)
 	}
 
-	err := json.Unmarshal([]byte(os.Args[1]), &pluginOptions)
 	app.SetPluginOptions(pluginOptions)
 	app.SetVersion(Version)
 
+	gitManager := app.NewStartupGitManager()
+	gitData, err := git.NewGitData(pluginOptions.ConnectionSettings.Remote, app.GitInstances(), gitManager)
+
 	if err != nil {
-		log.Fatalf("Failure parsing plugin settings: %v", err)
+		log.Fatalf("Failure initializing plugin: %v", err)
 	}
 
-	gitManager := git.Git{}
-	gitData, err := git.NewGitData(pluginOptions.ConnectionSettings.Remote, pluginOptions.GitlabUrl, gitManager)
-
+	err = app.UseInstance(gitData.GitlabUrl)
 	if err != nil {
 		log.Fatalf("Failure initializing plugin: %v", err)
 	}
 
+	err = app.ResolveAuthToken()
+	if err != nil {
+		log.Fatalf("Failure resolving auth token: %v", err)
+	}
+
 	client, err := app.NewClient()
 	if err != nil {
 		log.Fatalf("Failed to initialize Gitlab client: %v", err)
 	}
 
-	projectInfo, err := app.InitProjectSettings(client, gitData)
+	projectInfo, err := app.InitProjectSettings(context.Background(), client, gitData)
 	if err != nil {
 		log.Fatalf("Failed to initialize project settings: %v", err)
 	}
 
+	if pluginOptions.Stdio {
+		app.StartStdioServer(client, projectInfo, gitData)
+		return
+	}
+
 	app.StartServer(client, projectInfo, gitData)
 }
@@ -1,6 +1,7 @@
 local state = require("gitlab.state")
 local reviewer = require("gitlab.reviewer")
 local git = require("gitlab.git")
+local job = require("gitlab.job")
 local u = require("gitlab.utils")
 local M = {}
 
@@ -50,8 +51,9 @@ M.choose_merge_request = function(opts)
       end
 
       vim.schedule(function()
-        state.chosen_mr_iid = choice.iid
-        require("gitlab.server").restart(function()
+        local body = { branch = choice.source_branch, chosen_mr_iid = choice.iid }
+        job.run_job("/session", "PUT", body, function()
+          state.clear_data()
           if opts.open_reviewer then
             require("gitlab").review()
           end
@@ -4,9 +4,21 @@ local Job = require("plenary.job")
 local u = require("gitlab.utils")
 local M = {}
 
-M.run_job = function(endpoint, method, body, callback)
+-- Returns the curl arguments needed to reach the Go server, along with the headers that are
+-- written to curl's stdin so that the server secret never shows up in the process list
+M.server_args = function(endpoint, method)
   local state = require("gitlab.state")
-  local args = { "-s", "-X", (method or "POST"), string.format("localhost:%s", state.settings.port) .. endpoint }
+  local args = { "-s", "-X", (method or "POST"), state.server_url() .. endpoint, "-H", "@-" }
+  if state.settings.connection_settings.socket_path ~= "" then
+    table.insert(args, 1, "--unix-socket")
+    table.insert(args, 2, state.settings.connection_settings.socket_path)
+  end
+  local headers = string.format("Authorization: Bearer %s", state.server_secret or "")
+  return args, headers
+end
+
+M.run_job = function(endpoint, method, body, callback)
+  local args, headers = M.server_args(endpoint, method)
 
   if body ~= nil then
     local encoded_body = vim.json.encode(body)
@@ -21,6 +33,7 @@ M.run_job = function(endpoint, method, body, callback)
   Job:new({
     command = "curl",
     args = args,
+    writer = headers,
     on_stdout = function(_, output)
       vim.defer_fn(function()
         if output == nil then
@@ -50,7 +63,11 @@ M.run_job = function(endpoint, method, body, callback)
             return
           end
 
-          -- Handle error case
+          -- Handle error case, telling a slow Gitlab apart from a failing one
+          if data.code == "UPSTREAM_TIMEOUT" then
+            u.notify(string.format("%s: Gitlab did not respond in time", data.message), vim.log.levels.WARN)
+            return
+          end
           local message = string.format("%s: %s", data.message, data.details)
           u.notify(message, vim.log.levels.ERROR)
         end
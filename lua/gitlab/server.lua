@@ -38,32 +38,49 @@ M.start = function(callback)
     gitlab_url = state.settings.gitlab_url,
     port = port,
     auth_token = state.settings.auth_token,
+    auth = state.settings.auth,
+    instances = state.settings.instances,
     debug = state.settings.debug,
     log_path = state.settings.log_path,
+    cache_dir = state.settings.cache_dir,
     connection_settings = state.settings.connection_settings,
     chosen_mr_iid = state.chosen_mr_iid,
+    parent_pid = vim.fn.getpid(),
+    idle_timeout = state.settings.idle_timeout,
+    timeouts = state.settings.timeouts,
   }
 
   state.chosen_mr_iid = 0 -- Do not let this interfere with subsequent reviewer.open() calls
 
+  -- The settings hold the auth token, so they are written to stdin rather than showing up in the process list
   local settings = vim.json.encode(go_server_settings)
-  if vim.fn.has("win32") then
-    settings = settings:gsub('"', '\\"')
-  end
-
-  local command = string.format('"%s" "%s"', state.settings.bin, settings)
+  local command = { state.settings.bin, "--config", "-" }
 
   local job_id = vim.fn.jobstart(command, {
     on_stdout = function(_, data)
       -- if port was not provided then we need to parse it from output of server
       if parsed_port == nil then
         for _, line in ipairs(data) do
+          local sign_in = line:match("^Gitlab sign%-in:%s+(.+)$")
+          if sign_in ~= nil then
+            u.notify("To sign in to Gitlab, " .. sign_in, vim.log.levels.WARN)
+          end
+          local secret = line:match("Server secret:%s+(%x+)")
+          if secret ~= nil then
+            state.server_secret = secret
+          end
           port = line:match("Server started on port:%s+(%d+)")
           if port ~= nil then
             parsed_port = port
             state.settings.port = port
             break
           end
+          local socket_path = line:match("Server started on socket:%s+(.+)$")
+          if socket_path ~= nil then
+            parsed_port = socket_path
+            state.settings.connection_settings.socket_path = socket_path
+            break
+          end
         end
       end
 
@@ -99,7 +116,10 @@ M.start = function(callback)
   })
   if job_id <= 0 then
     u.notify("Could not start gitlab.nvim binary", vim.log.levels.ERROR)
+    return
   end
+  vim.fn.chansend(job_id, settings .. "\n")
+  vim.fn.chanclose(job_id, "stdin")
 end
 
 -- Builds the Go binary with the current Git tag.
@@ -185,13 +205,14 @@ M.get_version = function(callback)
   local version_output = vim.system({ "git", "describe", "--tags", "--always" }, { cwd = parent_dir }):wait()
   local plugin_version = version_output.code == 0 and vim.trim(version_output.stdout) or "unknown"
 
-  local args = { "-s", "-X", "GET", string.format("localhost:%s/version", state.settings.port) }
+  local args, headers = job.server_args("/version", "GET")
 
   -- We call the "/version" endpoint here instead of through the regular jobs pattern because earlier versions of the plugin
   -- may not have it. We handle a 404 as an "unknown" version error.
   Job:new({
     command = "curl",
     args = args,
+    writer = headers,
     on_stdout = function(_, output)
       vim.defer_fn(function()
         if output == nil then
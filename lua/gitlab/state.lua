@@ -51,9 +51,25 @@ M.settings = {
     response = false,
     gitlab_request = false,
     gitlab_response = false,
+    pprof = false,
   },
   log_path = (vim.fn.stdpath("cache") .. "/gitlab.nvim.log"),
+  idle_timeout = 0, -- seconds, 0 keeps the server running until Neovim exits
+  cache_dir = nil, -- disables the disk cache
+  timeouts = { default = 30 }, -- seconds per route of the Go server, 0 for none
   config_path = nil,
+  auth = {
+    token_env = "GITLAB_TOKEN",
+    token_file = nil,
+    credential_helper = false,
+    token_command = nil,
+    oauth = {
+      client_id = nil,
+      scopes = { "api" },
+      token_path = nil,
+    },
+  },
+  instances = nil, -- more Gitlab instances, picked by the host of the git remote
   reviewer = "diffview",
   reviewer_settings = {
     jump_with_no_diagnostics = false,
@@ -63,8 +79,21 @@ M.settings = {
   },
   connection_settings = {
     proxy = "",
+    no_proxy = nil, -- hosts reached without the proxy
     insecure = false,
+    ca_file = "",
+    client_cert = "",
+    client_key = "",
+    min_tls_version = "",
     remote = "origin",
+    socket_path = "",
+    max_in_flight = 10,
+    requests_per_second = 20,
+    retry = {
+      max_attempts = 3,
+      min_backoff_ms = 200,
+      max_backoff_ms = 5000,
+    },
   },
   attachment_dir = "",
   keymaps = {
@@ -437,7 +466,15 @@ M.setPluginConfiguration = function()
   M.settings.auth_token = token
   M.settings.gitlab_url = u.trim_slash(url or "https://gitlab.com")
 
-  if M.settings.auth_token == nil then
+  -- The Go server can also find the token on its own, see the `auth` settings
+  local auth = M.settings.auth or {}
+  local oauth = auth.oauth or {}
+  local server_finds_token = auth.token_file ~= nil
+    or auth.credential_helper
+    or auth.token_command ~= nil
+    or oauth.client_id ~= nil
+    or M.settings.instances ~= nil
+  if M.settings.auth_token == nil and not server_finds_token then
     vim.notify(
       "Missing authentication token for Gitlab, please provide it as an environment variable or in the .gitlab.nvim file",
       vim.log.levels.ERROR
@@ -564,6 +601,15 @@ M.load_new_state = function(dep, cb)
   )
 end
 
+-- Returns the base URL of the Go server. When the server listens on a Unix domain
+-- socket the host is ignored by curl, which connects through the socket instead.
+M.server_url = function()
+  if M.settings.connection_settings.socket_path ~= "" then
+    return "http://localhost"
+  end
+  return string.format("localhost:%s", M.settings.port)
+end
+
 -- This function clears out all of the previously fetched data. It's used
 -- to reset the plugin state when the Go server is restarted
 M.clear_data = function()